@@ -14,7 +14,34 @@ func GetUserContext(ctx context.Context) *types.JWTClaims {
 		return nil
 	}
 
-	return user.Claims.(*types.JWTClaims)
+	claims, _ := user.Claims.(*types.JWTClaims)
+	return claims
+}
+
+// GetClientContext : Get integrator client claims from context
+func GetClientContext(ctx context.Context) *types.JWTClaimsSignature {
+	switch user := ctx.Value("user").(type) {
+	case *jwt.Token:
+		claims, _ := user.Claims.(*types.JWTClaimsSignature)
+		return claims
+	case *types.JWTClaimsSignature:
+		return user
+	}
+
+	return nil
+}
+
+// GetPrincipal : Get the authenticated principal from context, regardless of the auth middleware used
+func GetPrincipal(ctx context.Context) types.Principal {
+	switch user := ctx.Value("user").(type) {
+	case *jwt.Token:
+		principal, _ := user.Claims.(types.Principal)
+		return principal
+	case types.Principal:
+		return user
+	}
+
+	return nil
 }
 
 // GetUserRawToken : Get user raw token from context
@@ -22,3 +49,15 @@ func GetUserRawToken(ctx context.Context) string {
 	user := ctx.Value("user").(*jwt.Token)
 	return user.Raw
 }
+
+// GetIntegratorClient : Get the integrator client resolved by ValidateAggregatorSignature from context
+func GetIntegratorClient(ctx context.Context) *types.IntegratorClient {
+	client, _ := ctx.Value("client").(*types.IntegratorClient)
+	return client
+}
+
+// GetTenant : Get the effective tenant namespace resolved by the tenant scope middleware from context
+func GetTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value("tenant").(string)
+	return tenant
+}
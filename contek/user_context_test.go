@@ -124,3 +124,138 @@ func TestGetUserRawToken(t *testing.T) {
 		})
 	}
 }
+
+func TestGetClientContext(t *testing.T) {
+	claims := &types.JWTClaimsSignature{ClientId: "client-1"}
+
+	tests := []struct {
+		name  string
+		setup func() context.Context
+		want  *types.JWTClaimsSignature
+	}{
+		{
+			name: "success - token with signature claims",
+			setup: func() context.Context {
+				return context.WithValue(context.Background(), "user", jwt.NewWithClaims(jwt.SigningMethodHS256, claims))
+			},
+			want: claims,
+		},
+		{
+			name: "success - bare signature claims",
+			setup: func() context.Context {
+				return context.WithValue(context.Background(), "user", claims)
+			},
+			want: claims,
+		},
+		{
+			name: "error - token with user claims",
+			setup: func() context.Context {
+				return context.WithValue(context.Background(), "user", jwt.NewWithClaims(jwt.SigningMethodHS256, &types.JWTClaims{ID: "123"}))
+			},
+			want: nil,
+		},
+		{
+			name: "error - no user in context",
+			setup: func() context.Context {
+				return context.Background()
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, contek.GetClientContext(tt.setup()))
+		})
+	}
+}
+
+func TestGetPrincipal(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func() context.Context
+		wantNil       bool
+		wantID        string
+		wantNamespace string
+		wantRole      types.Role
+		wantKind      types.PrincipalKind
+	}{
+		{
+			name: "success - admin user token",
+			setup: func() context.Context {
+				claims := &types.JWTClaims{ID: "123", Namespace: "agent-ns", Type: types.RoleAdmin}
+				return context.WithValue(context.Background(), "user", jwt.NewWithClaims(jwt.SigningMethodHS256, claims))
+			},
+			wantID:        "123",
+			wantNamespace: "agent-ns",
+			wantRole:      types.RoleAdmin,
+			wantKind:      types.PrincipalKindUser,
+		},
+		{
+			name: "success - integrator client token",
+			setup: func() context.Context {
+				claims := &types.JWTClaimsSignature{ClientId: "client-1"}
+				return context.WithValue(context.Background(), "user", jwt.NewWithClaims(jwt.SigningMethodHS256, claims))
+			},
+			wantID:   "client-1",
+			wantRole: types.RoleClient,
+			wantKind: types.PrincipalKindClient,
+		},
+		{
+			name: "success - bare integrator claims",
+			setup: func() context.Context {
+				return context.WithValue(context.Background(), "user", &types.JWTClaimsSignature{ClientId: "client-2"})
+			},
+			wantID:   "client-2",
+			wantRole: types.RoleClient,
+			wantKind: types.PrincipalKindClient,
+		},
+		{
+			name: "error - token with map claims",
+			setup: func() context.Context {
+				return context.WithValue(context.Background(), "user", jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{}))
+			},
+			wantNil: true,
+		},
+		{
+			name: "error - no user in context",
+			setup: func() context.Context {
+				return context.Background()
+			},
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := contek.GetPrincipal(tt.setup())
+
+			if tt.wantNil {
+				assert.Nil(t, got)
+				return
+			}
+
+			assert.NotNil(t, got)
+			assert.Equal(t, tt.wantID, got.PrincipalID())
+			assert.Equal(t, tt.wantNamespace, got.PrincipalNamespace())
+			assert.Equal(t, tt.wantRole, got.PrincipalRole())
+			assert.Equal(t, tt.wantKind, got.PrincipalKind())
+		})
+	}
+}
+
+func TestGetIntegratorClient(t *testing.T) {
+	client := &types.IntegratorClient{ID: "client-1", Status: types.Active}
+
+	ctx := context.WithValue(context.Background(), "client", client)
+	assert.Equal(t, client, contek.GetIntegratorClient(ctx))
+
+	assert.Nil(t, contek.GetIntegratorClient(context.Background()))
+}
+
+func TestGetTenant(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "tenant", "agent-ns")
+	assert.Equal(t, "agent-ns", contek.GetTenant(ctx))
+
+	assert.Empty(t, contek.GetTenant(context.Background()))
+}
@@ -46,10 +46,12 @@ var (
 	ErrInvalidToken         = Error{HTTPStatus: http.StatusUnauthorized, Code: 4010002, Message: "The provided access token is invalid"}
 	ErrMissingAuthorization = Error{HTTPStatus: http.StatusUnauthorized, Code: 4010003, Message: "Authorization header is missing"}
 	ErrExpiredToken         = Error{HTTPStatus: http.StatusUnauthorized, Code: 4010004, Message: "The access token has expired. Please login again"}
+	ErrStepUpRequired       = Error{HTTPStatus: http.StatusUnauthorized, Code: 4010005, Message: "This action requires a recent authentication. Please confirm your identity again"}
 
 	// Error 403
 	ErrForbidden      = Error{HTTPStatus: http.StatusForbidden, Code: 4030001, Message: "You do not have permission to access this resource"}
 	ErrSessionExpired = Error{HTTPStatus: http.StatusForbidden, Code: 4031001, Message: "Your session has expired"}
+	ErrIPNotAllowed   = Error{HTTPStatus: http.StatusForbidden, Code: 4031002, Message: "Your IP address is not allowed to access this resource"}
 
 	// Error 404
 	ErrProviderNotFound     = Error{HTTPStatus: http.StatusNotFound, Code: 4041001, Message: "The specified game provider could not be found"}
@@ -57,6 +59,7 @@ var (
 	ErrPlayerNotFound       = Error{HTTPStatus: http.StatusNotFound, Code: 4041003, Message: "The requested player could not be found."}
 	ErrGameNotFound         = Error{HTTPStatus: http.StatusNotFound, Code: 4041004, Message: "The requested game could not be found"}
 	ErrDuplicateTransaction = Error{HTTPStatus: http.StatusConflict, Code: 4091001, Message: "This transaction has already been processed"}
+	ErrSignatureReplayed    = Error{HTTPStatus: http.StatusConflict, Code: 4091002, Message: "This request signature has already been used"}
 	ErrRecordNotFound       = func(entity, id string) Error {
 		return Error{
 			HTTPStatus: http.StatusNotFound,
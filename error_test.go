@@ -54,11 +54,26 @@ func TestErrorConstants(t *testing.T) {
 			err:      common.ErrUnauthorized,
 			expected: common.Error{HTTPStatus: http.StatusUnauthorized, Code: 4010001, Message: "You are not authorized to access this resource"},
 		},
+		{
+			name:     "ErrStepUpRequired",
+			err:      common.ErrStepUpRequired,
+			expected: common.Error{HTTPStatus: http.StatusUnauthorized, Code: 4010005, Message: "This action requires a recent authentication. Please confirm your identity again"},
+		},
 		{
 			name:     "ErrForbidden",
 			err:      common.ErrForbidden,
 			expected: common.Error{HTTPStatus: http.StatusForbidden, Code: 4030001, Message: "You do not have permission to access this resource"},
 		},
+		{
+			name:     "ErrIPNotAllowed",
+			err:      common.ErrIPNotAllowed,
+			expected: common.Error{HTTPStatus: http.StatusForbidden, Code: 4031002, Message: "Your IP address is not allowed to access this resource"},
+		},
+		{
+			name:     "ErrSignatureReplayed",
+			err:      common.ErrSignatureReplayed,
+			expected: common.Error{HTTPStatus: http.StatusConflict, Code: 4091002, Message: "This request signature has already been used"},
+		},
 		{
 			name:     "ErrServerError",
 			err:      common.ErrServerError,
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetRouteAction : Require action instead of the action mapped from the method by types.MethodAction,
+// e.g. app.Post("/games/:id/regenerate", auth, SetRouteAction(regenerate), ValidatePermission(types.PermissionGames), handler)
+func SetRouteAction(action types.PermissionAction) fiber.Handler {
+	if action == 0 {
+		panic("route action is required")
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Locals("authz_action", action)
+		return c.Next()
+	}
+}
+
+// routeAction returns the action required by the request, set by SetRouteAction or mapped from the method
+func routeAction(c *fiber.Ctx) (types.PermissionAction, bool) {
+	if action, ok := c.Locals("authz_action").(types.PermissionAction); ok {
+		return action, true
+	}
+
+	action, ok := types.MethodAction[c.Method()]
+	return action, ok
+}
+
+// WithPreflightPassThrough : Answer CORS preflight requests with 204 No Content instead of requiring a token.
+// Browsers never send credentials with a preflight. The request ends here, the next handlers never run,
+// so the Access-Control headers must come from a CORS middleware mounted before, e.g. app.Use(cors.New(), auth).
+// Other OPTIONS requests require a token and the read action.
+func WithPreflightPassThrough() AuthOption {
+	return func(cfg *authConfig) {
+		cfg.preflightPassThrough = true
+	}
+}
+
+// isPreflight reports whether the request is a CORS preflight request
+func isPreflight(c *fiber.Ctx) bool {
+	return c.Method() == http.MethodOptions &&
+		c.Get(fiber.HeaderOrigin) != "" &&
+		c.Get(fiber.HeaderAccessControlRequestMethod) != ""
+}
@@ -0,0 +1,111 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var actionApprove = types.RegisterAction("approve")
+
+func init() {
+	types.ExtendPermission(types.PermissionPlayerPendingTxn, actionApprove)
+}
+
+func TestRouteActions(t *testing.T) {
+	reader := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{
+			types.PermissionGames:            types.ActionRead,
+			types.PermissionPlayerPendingTxn: types.ActionRead | types.ActionWrite,
+		}
+	}))
+	approver := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{types.PermissionPlayerPendingTxn: types.ActionRead | actionApprove}
+	}))
+
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusNoContent) }
+	app := fiber.New()
+	app.Add(http.MethodHead, "/games", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionGames), ok)
+	app.Options("/games", middleware.NewAuthMiddleware("secret", middleware.WithPreflightPassThrough()), middleware.ValidatePermission(types.PermissionGames), ok)
+	app.Options("/settings", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionSettings), ok)
+	app.Options("/reports", middleware.ValidatePermission(types.PermissionReportProfit), ok)
+	app.All("/wallet", middleware.NewAuthMiddleware("secret", middleware.WithPreflightPassThrough()), middleware.ValidatePermission(types.PermissionSettings),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+	app.Post("/pending/:id/approve",
+		middleware.NewAuthMiddleware("secret"), middleware.SetRouteAction(actionApprove),
+		middleware.ValidatePermission(types.PermissionPlayerPendingTxn), ok)
+
+	preflight := map[string]string{fiber.HeaderOrigin: "https://admin.example.com", fiber.HeaderAccessControlRequestMethod: http.MethodPut}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		token      string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{name: "success - HEAD requires read", method: http.MethodHead, target: "/games", token: reader, wantStatus: http.StatusNoContent},
+		{name: "success - OPTIONS requires read", method: http.MethodOptions, target: "/games", token: reader, wantStatus: http.StatusNoContent},
+		{name: "error - OPTIONS without read", method: http.MethodOptions, target: "/settings", token: reader, wantStatus: http.StatusForbidden},
+		{name: "success - preflight answered without token", method: http.MethodOptions, target: "/games", headers: preflight, wantStatus: http.StatusNoContent},
+		{name: "success - preflight does not reach the handler", method: http.MethodOptions, target: "/wallet", headers: preflight, wantStatus: http.StatusNoContent},
+		{name: "error - OPTIONS without preflight headers needs a token", method: http.MethodOptions, target: "/wallet", wantStatus: http.StatusUnauthorized},
+		{name: "error - preflight needs a token without pass-through", method: http.MethodOptions, target: "/settings", headers: preflight, wantStatus: http.StatusUnauthorized},
+		{name: "error - preflight is checked without pass-through", method: http.MethodOptions, target: "/settings", token: reader, headers: preflight, wantStatus: http.StatusForbidden},
+		{name: "error - preflight without authentication", method: http.MethodOptions, target: "/reports", headers: preflight, wantStatus: http.StatusUnauthorized},
+		{name: "success - route action granted", method: http.MethodPost, target: "/pending/1/approve", token: approver, wantStatus: http.StatusNoContent},
+		{name: "error - method action does not replace the route action", method: http.MethodPost, target: "/pending/1/approve", token: reader, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+
+	assert.Panics(t, func() { middleware.SetRouteAction(0) })
+}
+
+func TestEnforcePolicy_Preflight(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []middleware.AuthOption
+		wantStatus int
+	}{
+		{name: "success - pass-through", opts: []middleware.AuthOption{middleware.WithPreflightPassThrough()}, wantStatus: http.StatusNoContent},
+		{name: "error - without pass-through", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(middleware.NewAuthMiddleware("secret", tt.opts...), middleware.EnforcePolicy(testPolicyTable()))
+			app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodOptions, "/api/v1/settings", nil)
+			req.Header.Set(fiber.HeaderOrigin, "https://admin.example.com")
+			req.Header.Set(fiber.HeaderAccessControlRequestMethod, http.MethodDelete)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
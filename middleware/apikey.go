@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// APIKeyHeader is the default header holding the API key
+	APIKeyHeader = "X-API-Key"
+
+	defaultAPIKeyLookup = "header:" + APIKeyHeader
+)
+
+var (
+	// ErrAPIKeyNotFound is returned by an APIKeyStore when no key matches the prefix
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	errAPIKeyMalformed = errors.New("api key malformed")
+	errAPIKeyInvalid   = errors.New("api key invalid")
+)
+
+// APIKey is the stored record of an API key. Only the hash of the key is stored,
+// the prefix is the public part of the key used to look the record up and to identify it in logs.
+type APIKey struct {
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	ClientID  string     `json:"client_id"`
+	Namespace string     `json:"namespace,omitempty"`
+	Role      types.Role `json:"role,omitempty"`
+	// Scopes are "<permission>:<action>" entries, e.g. "report_profit:read" or "games:*"
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// APIKeyStore looks API keys up by prefix, e.g. from Valkey or the integrator database
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, prefix string) (*APIKey, error)
+}
+
+// GenerateAPIKey : Generate a new API key "<prefix>_<id>_<secret>", e.g. "agg_3f9a1c2b_...".
+// The key is returned once to hand out to the partner, only the returned record should be stored.
+func GenerateAPIKey(prefix string) (string, APIKey, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+
+	keyPrefix := prefix + "_" + hex.EncodeToString(id)
+	key := keyPrefix + "_" + hex.EncodeToString(secret)
+
+	return key, APIKey{Prefix: keyPrefix, Hash: HashAPIKey(key)}, nil
+}
+
+// HashAPIKey : Hash an API key for storage
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyPrefix returns the prefix of key, everything before the last "_"
+func apiKeyPrefix(key string) (string, error) {
+	i := strings.LastIndex(key, "_")
+	if i <= 0 || i == len(key)-1 {
+		return "", errAPIKeyMalformed
+	}
+
+	return key[:i], nil
+}
+
+// APIKeyOption configures NewAPIKeyMiddleware
+type APIKeyOption func(*apiKeyConfig)
+
+type apiKeyConfig struct {
+	lookup string
+}
+
+// WithAPIKeyLookup : Look the key up in the given sources instead of the X-API-Key header,
+// in the same format as WithTokenLookup, e.g. "header:X-API-Key,query:api_key"
+func WithAPIKeyLookup(lookup string) APIKeyOption {
+	return func(cfg *apiKeyConfig) {
+		cfg.lookup = lookup
+	}
+}
+
+// NewAPIKeyMiddleware : Initialize new instance for API key authentication middleware.
+// The key record is exposed as *types.JWTClaims with the key scopes as permissions,
+// so ValidatePermission, contek.GetUserContext and contek.GetPrincipal work unchanged.
+// Keys without a role get types.RoleAPIKey, which grants nothing beyond the key scopes.
+// The defaults of a role set on the key are merged on top of its scopes.
+func NewAPIKeyMiddleware(store APIKeyStore, opts ...APIKeyOption) fiber.Handler {
+	cfg := apiKeyConfig{lookup: defaultAPIKeyLookup}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	extractors, err := tokenExtractors(cfg.lookup)
+	if err != nil {
+		panic("api key middleware configuration: " + err.Error())
+	}
+
+	return func(c *fiber.Ctx) error {
+		key, err := extractToken(c, extractors)
+		if err != nil {
+			return common.Response().SetError(common.ErrMissingAuthorization).Send(c)
+		}
+
+		record, err := verifyAPIKey(c.Context(), store, key)
+		switch {
+		case errors.Is(err, ErrAPIKeyNotFound), errors.Is(err, errAPIKeyMalformed), errors.Is(err, errAPIKeyInvalid):
+			slog.Warn("API key rejected", "path", c.Path(), "error", err.Error())
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		case err != nil:
+			slog.Warn("API key lookup failed", "error", err.Error())
+			return common.Response().SetError(common.ErrServerError).Send(c)
+		}
+
+		c.Locals("user", &jwt.Token{Claims: apiKeyClaims(record), Valid: true})
+		return c.Next()
+	}
+}
+
+// verifyAPIKey looks the key record up by prefix and checks the key hash, expiry and revocation
+func verifyAPIKey(ctx context.Context, store APIKeyStore, key string) (*APIKey, error) {
+	prefix, err := apiKeyPrefix(key)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := store.LookupAPIKey(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(record.Hash)) != 1 {
+		return nil, fmt.Errorf("%w: hash mismatch for prefix %s", errAPIKeyInvalid, prefix)
+	}
+	if record.Revoked {
+		return nil, fmt.Errorf("%w: key %s is revoked", errAPIKeyInvalid, prefix)
+	}
+	if !record.ExpiresAt.IsZero() && !time.Now().Before(record.ExpiresAt) {
+		return nil, fmt.Errorf("%w: key %s expired", errAPIKeyInvalid, prefix)
+	}
+
+	return record, nil
+}
+
+// apiKeyClaims builds the claims of an API key, the prefix is used as the token id
+func apiKeyClaims(record *APIKey) *types.JWTClaims {
+	claims := &types.JWTClaims{
+		ID:        record.ClientID,
+		Namespace: record.Namespace,
+		Type:      record.Role,
+	}
+	if claims.Type == "" {
+		claims.Type = types.RoleAPIKey
+	}
+	claims.RegisteredClaims.ID = record.Prefix
+	claims.Subject = record.ClientID
+	if !record.ExpiresAt.IsZero() {
+		claims.ExpiresAt = jwt.NewNumericDate(record.ExpiresAt)
+	}
+
+	for _, scope := range record.Scopes {
+		permission, action, err := parseAPIKeyScope(scope)
+		if err != nil {
+			slog.Warn("Invalid API key scope", "prefix", record.Prefix, "scope", scope)
+			continue
+		}
+		claims.Permissions.Grant(permission, action)
+	}
+
+	return claims
+}
+
+// parseAPIKeyScope parses a "<permission>:<action>" scope, action is read, write, delete or "*"
+func parseAPIKeyScope(scope string) (types.Permission, types.PermissionAction, error) {
+	name, actionName, ok := strings.Cut(scope, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid scope %q", scope)
+	}
+
+	def, ok := types.LookupPermission(name)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown permission in scope %q", scope)
+	}
+
+	action, ok := types.ParsePermissionAction(actionName)
+	if strings.TrimSpace(actionName) == "*" {
+		action, ok = def.Actions, true
+	}
+	if !ok {
+		return "", 0, fmt.Errorf("unknown action in scope %q", scope)
+	}
+	if action&def.Actions == 0 {
+		return "", 0, fmt.Errorf("action not allowed for permission in scope %q", scope)
+	}
+
+	return def.Key, action, nil
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore, suitable for tests
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+// NewMemoryAPIKeyStore : Initialize new in-memory API key store holding the given keys
+func NewMemoryAPIKeyStore(keys ...APIKey) *MemoryAPIKeyStore {
+	s := &MemoryAPIKeyStore{keys: make(map[string]APIKey, len(keys))}
+	for _, key := range keys {
+		s.keys[key.Prefix] = key
+	}
+
+	return s
+}
+
+// Set adds or replaces a key
+func (s *MemoryAPIKeyStore) Set(key APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.Prefix] = key
+}
+
+// LookupAPIKey implements APIKeyStore
+func (s *MemoryAPIKeyStore) LookupAPIKey(_ context.Context, prefix string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[prefix]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,136 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingAPIKeyStore struct{}
+
+func (failingAPIKeyStore) LookupAPIKey(context.Context, string) (*middleware.APIKey, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func newAPIKey(t *testing.T, mutate func(*middleware.APIKey)) (string, middleware.APIKey) {
+	t.Helper()
+
+	key, record, err := middleware.GenerateAPIKey("agg")
+	require.NoError(t, err)
+	record.ClientID = "partner-1"
+	record.Namespace = "partner-ns"
+	record.Scopes = []string{"report_profit:read", "games:*", "unknown:read"}
+	if mutate != nil {
+		mutate(&record)
+	}
+
+	return key, record
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, record, err := middleware.GenerateAPIKey("agg")
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(key, record.Prefix+"_"))
+	assert.True(t, strings.HasPrefix(record.Prefix, "agg_"))
+	assert.Equal(t, middleware.HashAPIKey(key), record.Hash)
+	assert.NotContains(t, record.Hash, key)
+}
+
+func TestNewAPIKeyMiddleware(t *testing.T) {
+	key, record := newAPIKey(t, nil)
+	revokedKey, revoked := newAPIKey(t, func(k *middleware.APIKey) { k.Revoked = true })
+	expiredKey, expired := newAPIKey(t, func(k *middleware.APIKey) { k.ExpiresAt = time.Now().Add(-time.Minute) })
+	store := middleware.NewMemoryAPIKeyStore(record, revoked, expired)
+
+	app := fiber.New()
+	app.Get("/reports/profit",
+		middleware.NewAPIKeyMiddleware(store, middleware.WithAPIKeyLookup("header:X-API-Key,query:api_key")),
+		middleware.ValidatePermission(types.PermissionReportProfit),
+		func(c *fiber.Ctx) error {
+			user := contek.GetUserContext(c.Context())
+			return c.SendString(user.ID + "|" + user.Namespace + "|" + string(contek.GetPrincipal(c.Context()).PrincipalRole()))
+		},
+	)
+	app.Post("/reports/profit",
+		middleware.NewAPIKeyMiddleware(store),
+		middleware.ValidatePermission(types.PermissionReportProfit),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) },
+	)
+	app.Delete("/games",
+		middleware.NewAPIKeyMiddleware(store),
+		middleware.ValidatePermission(types.PermissionGames),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) },
+	)
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		header        string
+		wantStatus    int
+		wantBody      string
+		wantErrorCode int
+	}{
+		{name: "success - header key with read scope", method: http.MethodGet, target: "/reports/profit", header: key, wantStatus: http.StatusOK, wantBody: "partner-1|partner-ns|api_key"},
+		{name: "success - query key", method: http.MethodGet, target: "/reports/profit?api_key=" + key, wantStatus: http.StatusOK, wantBody: "partner-1|partner-ns|api_key"},
+		{name: "success - wildcard scope", method: http.MethodDelete, target: "/games", header: key, wantStatus: http.StatusOK},
+		{name: "error - action outside scope", method: http.MethodPost, target: "/reports/profit", header: key, wantStatus: http.StatusForbidden, wantErrorCode: common.ErrForbidden.Code},
+		{name: "error - missing key", method: http.MethodGet, target: "/reports/profit", wantStatus: http.StatusUnauthorized, wantErrorCode: common.ErrMissingAuthorization.Code},
+		{name: "error - wrong secret", method: http.MethodGet, target: "/reports/profit", header: record.Prefix + "_deadbeef", wantStatus: http.StatusUnauthorized, wantErrorCode: common.ErrUnauthorized.Code},
+		{name: "error - unknown prefix", method: http.MethodGet, target: "/reports/profit", header: "agg_00000000_deadbeef", wantStatus: http.StatusUnauthorized, wantErrorCode: common.ErrUnauthorized.Code},
+		{name: "error - malformed key", method: http.MethodGet, target: "/reports/profit", header: "nope", wantStatus: http.StatusUnauthorized, wantErrorCode: common.ErrUnauthorized.Code},
+		{name: "error - revoked key", method: http.MethodGet, target: "/reports/profit", header: revokedKey, wantStatus: http.StatusUnauthorized, wantErrorCode: common.ErrUnauthorized.Code},
+		{name: "error - expired key", method: http.MethodGet, target: "/reports/profit", header: expiredKey, wantStatus: http.StatusUnauthorized, wantErrorCode: common.ErrUnauthorized.Code},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RequestURI = tt.target
+			if tt.header != "" {
+				req.Header.Set(middleware.APIKeyHeader, tt.header)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantErrorCode != 0 {
+				assert.Equal(t, tt.wantErrorCode, responseCode(t, resp))
+			}
+			if tt.wantBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
+
+func TestNewAPIKeyMiddleware_StoreError(t *testing.T) {
+	app := fiber.New()
+	app.Get("/reports", middleware.NewAPIKeyMiddleware(failingAPIKeyStore{}), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+	req.Header.Set(middleware.APIKeyHeader, "agg_00000000_deadbeef")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, common.ErrServerError.Code, responseCode(t, resp))
+}
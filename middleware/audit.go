@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuditOutcome is the outcome of an authorization decision
+type AuditOutcome string
+
+const (
+	AuditGranted AuditOutcome = "granted"
+	AuditDenied  AuditOutcome = "denied"
+	AuditError   AuditOutcome = "error" // no decision could be made, the request was denied
+)
+
+// AuditEvent records one authorization decision
+type AuditEvent struct {
+	Time      time.Time           `json:"time"`
+	RequestID string              `json:"request_id,omitempty"`
+	Principal string              `json:"principal"`
+	Kind      types.PrincipalKind `json:"kind,omitempty"`
+	Namespace string              `json:"namespace,omitempty"`
+	// Permission is the permission checked, or the required permissions joined with "," when none granted the action.
+	// For Requirement handlers it is the permissions checked for the decision joined with ",".
+	Permission string       `json:"permission,omitempty"`
+	Actions    []string     `json:"actions,omitempty"`
+	Method     string       `json:"method"`
+	Route      string       `json:"route"`
+	Path       string       `json:"path"`
+	Outcome    AuditOutcome `json:"outcome"`
+	Reason     string       `json:"reason,omitempty"`
+}
+
+// AuditSink receives the authorization decisions of ValidatePermission, EnforcePolicy and Requirement handlers
+type AuditSink interface {
+	Audit(ctx context.Context, event AuditEvent) error
+}
+
+var auditSink atomic.Pointer[AuditSink]
+
+// SetAuditSink : Send every authorization decision to sink, auditing is disabled when nil.
+// It can be called while requests are served.
+func SetAuditSink(sink AuditSink) {
+	if sink == nil {
+		auditSink.Store(nil)
+		return
+	}
+	auditSink.Store(&sink)
+}
+
+// audit sends an event to the sink set with SetAuditSink, a failing sink is logged and does not change the decision
+func audit(c *fiber.Ctx, principal types.Principal, event AuditEvent) {
+	sink := auditSink.Load()
+	if sink == nil {
+		return
+	}
+
+	// Values returned by fiber are only valid during the request, sinks may keep the event longer
+	event.Time = time.Now().UTC()
+	event.RequestID = strings.Clone(requestID(c))
+	event.Method = strings.Clone(c.Method())
+	event.Path = strings.Clone(c.Path())
+	if event.Route == "" {
+		event.Route = c.Route().Path
+	}
+	if principal != nil {
+		event.Principal = principal.PrincipalID()
+		event.Kind = principal.PrincipalKind()
+		event.Namespace = principal.PrincipalNamespace()
+	}
+
+	if err := (*sink).Audit(c.Context(), event); err != nil {
+		slog.Error("Audit event not recorded", "outcome", event.Outcome, "principal", event.Principal, "error", err.Error())
+	}
+}
+
+// auditPreflight records a CORS preflight request answered without a token, see WithPreflightPassThrough
+func auditPreflight(c *fiber.Ctx) {
+	audit(c, nil, AuditEvent{Outcome: AuditGranted, Reason: "CORS preflight"})
+}
+
+// auditUnauthenticated records a request denied by a permission check for lack of claims
+func auditUnauthenticated(c *fiber.Ctx) {
+	audit(c, nil, AuditEvent{Outcome: AuditDenied, Reason: "not authenticated"})
+}
+
+// requestID returns the id set by the fiber requestid middleware, or the X-Request-ID header
+func requestID(c *fiber.Ctx) string {
+	if id, ok := c.Locals("requestid").(string); ok && id != "" {
+		return id
+	}
+
+	return c.Get(fiber.HeaderXRequestID)
+}
+
+// JSONLinesAuditSink writes one JSON object per line
+type JSONLinesAuditSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewJSONLinesAuditSink : Initialize new audit sink writing to w
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{w: w}
+}
+
+// OpenJSONLinesAuditSink : Initialize new audit sink appending to the file at path, created when missing
+func OpenJSONLinesAuditSink(path string) (*JSONLinesAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit file: %w", err)
+	}
+
+	return &JSONLinesAuditSink{w: f, closer: f}, nil
+}
+
+// Audit implements AuditSink
+func (s *JSONLinesAuditSink) Audit(_ context.Context, event AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the file opened by OpenJSONLinesAuditSink
+func (s *JSONLinesAuditSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// MemoryAuditSink keeps audit events in memory, for tests
+type MemoryAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+// NewMemoryAuditSink : Initialize new in-memory audit sink
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+// Audit implements AuditSink
+func (s *MemoryAuditSink) Audit(_ context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns a copy of the recorded events
+func (s *MemoryAuditSink) Events() []AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]AuditEvent(nil), s.events...)
+}
+
+// Reset drops the recorded events
+func (s *MemoryAuditSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = nil
+}
+
+// sampledAuditSink forwards a fraction of the grants and every other event
+type sampledAuditSink struct {
+	sink      AuditSink
+	grantRate float64
+}
+
+// NewSampledAuditSink : Forward grants to sink with probability grantRate, between 0 and 1.
+// Denials and errors are never sampled.
+func NewSampledAuditSink(sink AuditSink, grantRate float64) AuditSink {
+	if grantRate < 0 || grantRate > 1 {
+		panic(fmt.Sprintf("audit sampling: grant rate %v out of [0, 1]", grantRate))
+	}
+
+	return &sampledAuditSink{sink: sink, grantRate: grantRate}
+}
+
+// Audit implements AuditSink
+func (s *sampledAuditSink) Audit(ctx context.Context, event AuditEvent) error {
+	if event.Outcome == AuditGranted && (s.grantRate == 0 || rand.Float64() >= s.grantRate) {
+		return nil
+	}
+
+	return s.sink.Audit(ctx, event)
+}
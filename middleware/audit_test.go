@@ -0,0 +1,213 @@
+package middleware_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useAuditSink(t *testing.T, sink middleware.AuditSink) {
+	t.Helper()
+
+	middleware.SetAuditSink(sink)
+	t.Cleanup(func() { middleware.SetAuditSink(nil) })
+}
+
+func TestValidatePermission_Audit(t *testing.T) {
+	sink := middleware.NewMemoryAuditSink()
+	useAuditSink(t, sink)
+
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	app.All("/games/:id", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionGames, types.PermissionAgent), ok)
+	app.Get("/me", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(), ok)
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.ID = "user-1"
+		c.Namespace = "ns-1"
+		c.Permissions = types.PermissionsDTO{types.PermissionGames: types.ActionRead}
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   middleware.AuditEvent
+	}{
+		{
+			name:   "success - grant",
+			method: http.MethodGet,
+			target: "/games/1",
+			want: middleware.AuditEvent{
+				RequestID: "req-1", Principal: "user-1", Kind: types.PrincipalKindUser, Namespace: "ns-1",
+				Permission: "games", Actions: []string{"read"}, Method: http.MethodGet, Route: "/games/:id", Path: "/games/1",
+				Outcome: middleware.AuditGranted, Reason: "games grants [read]",
+			},
+		},
+		{
+			name:   "error - denial lists every required permission",
+			method: http.MethodDelete,
+			target: "/games/1",
+			want: middleware.AuditEvent{
+				RequestID: "req-1", Principal: "user-1", Kind: types.PrincipalKindUser, Namespace: "ns-1",
+				Permission: "games,agent", Actions: []string{"delete"}, Method: http.MethodDelete, Route: "/games/:id", Path: "/games/1",
+				Outcome: middleware.AuditDenied, Reason: "games does not grant [delete], have [read]; agent does not grant [delete], have []",
+			},
+		},
+		{
+			name:   "error - queried permission not required",
+			method: http.MethodGet,
+			target: "/games/1?type=settings",
+			want: middleware.AuditEvent{
+				RequestID: "req-1", Principal: "user-1", Kind: types.PrincipalKindUser, Namespace: "ns-1",
+				Permission: "settings", Actions: []string{"read"}, Method: http.MethodGet, Route: "/games/:id", Path: "/games/1",
+				Outcome: middleware.AuditDenied, Reason: "queried permission not in required list",
+			},
+		},
+		{
+			name:   "success - no permission required",
+			method: http.MethodGet,
+			target: "/me",
+			want: middleware.AuditEvent{
+				RequestID: "req-1", Principal: "user-1", Kind: types.PrincipalKindUser, Namespace: "ns-1",
+				Actions: []string{"read"}, Method: http.MethodGet, Route: "/me", Path: "/me",
+				Outcome: middleware.AuditGranted, Reason: "no permission required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink.Reset()
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RequestURI = tt.target
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			req.Header.Set(fiber.HeaderXRequestID, "req-1")
+			_, err := app.Test(req)
+			require.NoError(t, err)
+
+			events := sink.Events()
+			require.Len(t, events, 1)
+			assert.False(t, events[0].Time.IsZero())
+			events[0].Time = tt.want.Time
+			assert.Equal(t, tt.want, events[0])
+		})
+	}
+}
+
+func TestEnforcePolicy_Audit(t *testing.T) {
+	sink := middleware.NewMemoryAuditSink()
+	useAuditSink(t, sink)
+
+	app := fiber.New()
+	app.Use(middleware.NewAuthMiddleware("secret", middleware.WithPreflightPassThrough()), middleware.EnforcePolicy(testPolicyTable()))
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{types.PermissionGames: types.ActionRead}
+	}))
+
+	for _, target := range []string{"/api/v1/games/42", "/api/v1/settings", "/me"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		_, err := app.Test(req)
+		require.NoError(t, err)
+	}
+	preflight := httptest.NewRequest(http.MethodOptions, "/api/v1/settings", nil)
+	preflight.Header.Set(fiber.HeaderOrigin, "https://panel.example.com")
+	preflight.Header.Set(fiber.HeaderAccessControlRequestMethod, http.MethodDelete)
+	_, err := app.Test(preflight)
+	require.NoError(t, err)
+
+	events := sink.Events()
+	require.Len(t, events, 4)
+	assert.Equal(t, middleware.AuditGranted, events[0].Outcome)
+	assert.Equal(t, "/api/v1/games/:id", events[0].Route)
+	assert.Equal(t, "/api/v1/games/42", events[0].Path)
+	assert.Equal(t, middleware.AuditDenied, events[1].Outcome)
+	assert.Equal(t, "no policy for route", events[1].Reason)
+	assert.Equal(t, middleware.AuditGranted, events[2].Outcome)
+	assert.Equal(t, "/me", events[2].Route)
+	assert.Equal(t, "public policy", events[2].Reason)
+	assert.Equal(t, middleware.AuditGranted, events[3].Outcome)
+	assert.Equal(t, "CORS preflight", events[3].Reason)
+	assert.Empty(t, events[3].Principal)
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := middleware.OpenJSONLinesAuditSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Audit(context.Background(), middleware.AuditEvent{Principal: "user-1", Outcome: middleware.AuditGranted}))
+	require.NoError(t, sink.Audit(context.Background(), middleware.AuditEvent{Principal: "user-2", Outcome: middleware.AuditDenied, Reason: "no policy for route"}))
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []middleware.AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event middleware.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, events, 2)
+	assert.Equal(t, "user-1", events[0].Principal)
+	assert.Equal(t, middleware.AuditDenied, events[1].Outcome)
+	assert.Equal(t, "no policy for route", events[1].Reason)
+}
+
+func TestSampledAuditSink(t *testing.T) {
+	outcomes := []middleware.AuditOutcome{middleware.AuditGranted, middleware.AuditDenied, middleware.AuditError}
+
+	tests := []struct {
+		name      string
+		grantRate float64
+		want      []middleware.AuditOutcome
+	}{
+		{name: "success - grants dropped, denials kept", grantRate: 0, want: []middleware.AuditOutcome{middleware.AuditDenied, middleware.AuditError}},
+		{name: "success - everything kept", grantRate: 1, want: outcomes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := middleware.NewMemoryAuditSink()
+			sink := middleware.NewSampledAuditSink(memory, tt.grantRate)
+			for i := 0; i < 100; i++ {
+				for _, outcome := range outcomes {
+					require.NoError(t, sink.Audit(context.Background(), middleware.AuditEvent{Outcome: outcome}))
+				}
+			}
+
+			counts := make(map[middleware.AuditOutcome]int)
+			for _, event := range memory.Events() {
+				counts[event.Outcome]++
+			}
+			want := make(map[middleware.AuditOutcome]int)
+			for _, outcome := range tt.want {
+				want[outcome] = 100
+			}
+			assert.Equal(t, want, counts)
+		})
+	}
+
+	assert.Panics(t, func() { middleware.NewSampledAuditSink(middleware.NewMemoryAuditSink(), 1.5) })
+}
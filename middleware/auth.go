@@ -3,53 +3,173 @@ package middleware
 import (
 	"errors"
 	"fmt"
-	"os"
-	"reflect"
+	"log/slog"
+	"strings"
 
 	common "github.com/SoeltanIT/agg-common-be"
 	"github.com/SoeltanIT/agg-common-be/types"
-	jwtware "github.com/gofiber/contrib/jwt"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // NewAuthMiddlewareSignature : Initialize new instance for signature authentication middleware.
-func NewAuthMiddlewareSignature(secret string) fiber.Handler {
-	return jwtware.New(jwtware.Config{
-		SigningKey: jwtware.SigningKey{Key: []byte(secret)},
-		Claims:     &types.JWTClaimsSignature{},
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return common.Response().SetError(common.ErrUnauthorized).Send(c)
-		},
-	})
+// Tokens are verified with the HS256 secret by default, see AuthOption for asymmetric keys and JWKS.
+func NewAuthMiddlewareSignature(secret string, opts ...AuthOption) fiber.Handler {
+	return newAuthHandler(secret, opts, func() jwt.Claims { return &types.JWTClaimsSignature{} })
 }
 
 // NewAuthMiddleware : Initialize new instance for authentication middleware.
-func NewAuthMiddleware(secret string) fiber.Handler {
-	return jwtware.New(jwtware.Config{
-		SigningKey: jwtware.SigningKey{Key: []byte(secret)},
-		Claims:     &types.JWTClaims{},
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return common.Response().SetError(common.ErrUnauthorized).Send(c)
-		},
-	})
-}
-
-func ValidateSocketToken(auth any) error {
-	if auth != nil {
-		result := make(map[string]string)
-		v := reflect.ValueOf(auth)
-		for _, key := range v.MapKeys() {
-			val := v.MapIndex(key)
-			result[key.String()] = fmt.Sprintf("%v", val.Interface())
+// Tokens are verified with the HS256 secret by default, see AuthOption for asymmetric keys and JWKS.
+func NewAuthMiddleware(secret string, opts ...AuthOption) fiber.Handler {
+	return newAuthHandler(secret, opts, func() jwt.Claims { return &types.JWTClaims{} })
+}
+
+const defaultTokenLookup = "header:" + fiber.HeaderAuthorization
+
+var (
+	errMissingToken   = errors.New("token not found in request")
+	errMalformedToken = errors.New("authorization header is not a bearer token")
+)
+
+// WithTokenLookup : Look the token up in the given sources, in order, instead of the Authorization header.
+// The format is "<source>:<name>" separated by commas, where source is header, cookie, query or param,
+// e.g. "header:Authorization,cookie:access_token" or "query:token" for websocket upgrades.
+// The Authorization header is read with the Bearer scheme, other sources hold the raw token.
+func WithTokenLookup(lookup string) AuthOption {
+	return func(cfg *authConfig) {
+		cfg.tokenLookup = lookup
+	}
+}
+
+func newAuthHandler(secret string, opts []AuthOption, newClaims func() jwt.Claims) fiber.Handler {
+	cfg := newAuthConfig(secret, opts)
+	if len(cfg.staticSecrets) > 0 {
+		panic("auth middleware configuration: WithStaticSecrets is only supported by NewSocketAuthenticator")
+	}
+	verifier, err := newTokenVerifier(cfg)
+	if err != nil {
+		panic("auth middleware configuration: " + err.Error())
+	}
+	extractors, err := tokenExtractors(cfg.tokenLookup)
+	if err != nil {
+		panic("auth middleware configuration: " + err.Error())
+	}
+
+	return func(c *fiber.Ctx) error {
+		if cfg.preflightPassThrough && isPreflight(c) {
+			auditPreflight(c)
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		raw, err := extractToken(c, extractors)
+		if err == nil {
+			var token *jwt.Token
+			token, err = verifier.verify(c.Context(), raw, newClaims())
+			if err == nil {
+				c.Locals("user", token)
+				return c.Next()
+			}
+		}
+
+		appErr := classifyTokenError(err)
+		switch appErr {
+		case common.ErrServerError:
+			slog.Warn("Token revocation check failed", "error", err.Error())
+		case common.ErrInvalidToken, common.ErrExpiredToken:
+			slog.Debug("Token verification failed", "path", c.Path(), "error", err.Error())
+		}
+
+		return common.Response().SetError(appErr).Send(c)
+	}
+}
+
+// classifyTokenError maps token lookup and verification errors to response errors
+func classifyTokenError(err error) common.Error {
+	switch {
+	case errors.Is(err, errMissingToken):
+		return common.ErrMissingAuthorization
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return common.ErrExpiredToken
+	case errors.Is(err, errRevocationCheck):
+		return common.ErrServerError
+	case errors.Is(err, errTokenRevoked),
+		errors.Is(err, errMalformedToken),
+		errors.Is(err, jwt.ErrTokenMalformed),
+		errors.Is(err, jwt.ErrTokenUnverifiable),
+		errors.Is(err, jwt.ErrTokenSignatureInvalid),
+		errors.Is(err, jwt.ErrTokenInvalidClaims),
+		errors.Is(err, jwt.ErrTokenNotValidYet),
+		errors.Is(err, jwt.ErrTokenUsedBeforeIssued),
+		errors.Is(err, jwt.ErrTokenInvalidIssuer),
+		errors.Is(err, jwt.ErrTokenInvalidAudience),
+		errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return common.ErrInvalidToken
+	default:
+		return common.ErrUnauthorized
+	}
+}
+
+type tokenExtractor func(c *fiber.Ctx) (string, error)
+
+// tokenExtractors parses a lookup string such as "header:Authorization,query:token"
+func tokenExtractors(lookup string) ([]tokenExtractor, error) {
+	var extractors []tokenExtractor
+	for _, part := range strings.Split(lookup, ",") {
+		source, name, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid token lookup %q", part)
 		}
 
-		tokenString, ok := result["token"]
-		if ok {
-			if tokenString == os.Getenv("STATIC_SECRET") {
-				return nil
+		switch source {
+		case "header":
+			if strings.EqualFold(name, fiber.HeaderAuthorization) {
+				extractors = append(extractors, bearerToken)
+				continue
 			}
+			extractors = append(extractors, func(c *fiber.Ctx) (string, error) { return c.Get(name), nil })
+		case "cookie":
+			extractors = append(extractors, func(c *fiber.Ctx) (string, error) { return c.Cookies(name), nil })
+		case "query":
+			extractors = append(extractors, func(c *fiber.Ctx) (string, error) { return c.Query(name), nil })
+		case "param":
+			extractors = append(extractors, func(c *fiber.Ctx) (string, error) { return c.Params(name), nil })
+		default:
+			return nil, fmt.Errorf("invalid token lookup source %q", source)
 		}
 	}
 
-	return errors.New("unauthorized")
+	return extractors, nil
+}
+
+// extractToken returns the first token found, errMalformedToken when only a malformed
+// Authorization header was found and errMissingToken when no source holds a token
+func extractToken(c *fiber.Ctx, extractors []tokenExtractor) (string, error) {
+	err := errMissingToken
+	for _, extract := range extractors {
+		raw, extractErr := extract(c)
+		if extractErr != nil {
+			err = extractErr
+			continue
+		}
+		if raw != "" {
+			return raw, nil
+		}
+	}
+
+	return "", err
+}
+
+// bearerToken extracts the token from the "Authorization: Bearer <token>" header
+func bearerToken(c *fiber.Ctx) (string, error) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if auth == "" {
+		return "", nil
+	}
+
+	const scheme = "Bearer"
+	if len(auth) > len(scheme)+1 && strings.EqualFold(auth[:len(scheme)+1], scheme+" ") {
+		return strings.TrimSpace(auth[len(scheme):]), nil
+	}
+
+	return "", errMalformedToken
 }
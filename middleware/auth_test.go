@@ -0,0 +1,423 @@
+package middleware_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/middleware/jwkstest"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const defaultLookup = "header:Authorization"
+
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return raw
+}
+
+func userClaims(mutate ...func(*types.JWTClaims)) *types.JWTClaims {
+	claims := &types.JWTClaims{
+		ID:        "user-1",
+		Namespace: "agent-ns",
+		Type:      types.RoleAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	for _, m := range mutate {
+		m(claims)
+	}
+
+	return claims
+}
+
+// protectedApp mounts handler in front of a route echoing the authenticated principal id
+func protectedApp(handler fiber.Handler) *fiber.App {
+	app := fiber.New()
+	app.Get("/me", handler, func(c *fiber.Ctx) error {
+		return c.SendString(contek.GetPrincipal(c.Context()).PrincipalID())
+	})
+
+	return app
+}
+
+func doRequest(t *testing.T, app *fiber.App, token string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestNewAuthMiddleware_Keys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		secret     string
+		opts       []middleware.AuthOption
+		method     jwt.SigningMethod
+		signKey    any
+		wantStatus int
+	}{
+		{
+			name:       "success - HS256 secret",
+			secret:     "secret",
+			method:     jwt.SigningMethodHS256,
+			signKey:    []byte("secret"),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "error - HS256 wrong secret",
+			secret:     "secret",
+			method:     jwt.SigningMethodHS256,
+			signKey:    []byte("other"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "success - RS256 public key",
+			opts:       []middleware.AuthOption{middleware.WithPublicKey(&rsaKey.PublicKey)},
+			method:     jwt.SigningMethodRS256,
+			signKey:    rsaKey,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "success - ES256 public key",
+			opts:       []middleware.AuthOption{middleware.WithPublicKey(&ecKey.PublicKey)},
+			method:     jwt.SigningMethodES256,
+			signKey:    ecKey,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "success - EdDSA public key",
+			opts:       []middleware.AuthOption{middleware.WithPublicKey(edPub)},
+			method:     jwt.SigningMethodEdDSA,
+			signKey:    edKey,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "error - HS256 token when only public key is configured",
+			opts:       []middleware.AuthOption{middleware.WithPublicKey(&rsaKey.PublicKey)},
+			method:     jwt.SigningMethodHS256,
+			signKey:    []byte("secret"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "error - ES256 token when only RSA key is configured",
+			opts:       []middleware.AuthOption{middleware.WithPublicKey(&rsaKey.PublicKey)},
+			method:     jwt.SigningMethodES256,
+			signKey:    ecKey,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := protectedApp(middleware.NewAuthMiddleware(tt.secret, tt.opts...))
+			resp := doRequest(t, app, signToken(t, tt.method, tt.signKey, "", userClaims()))
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestNewAuthMiddleware_ErrorCodes(t *testing.T) {
+	expired := userClaims(func(c *types.JWTClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) })
+	notYetValid := userClaims(func(c *types.JWTClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) })
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantCode      int
+	}{
+		{
+			name:       "missing token",
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   common.ErrMissingAuthorization.Code,
+		},
+		{
+			name:          "expired token",
+			authorization: "Bearer " + signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", expired),
+			wantStatus:    http.StatusUnauthorized,
+			wantCode:      common.ErrExpiredToken.Code,
+		},
+		{
+			name:          "malformed token",
+			authorization: "Bearer not.a.jwt",
+			wantStatus:    http.StatusUnauthorized,
+			wantCode:      common.ErrInvalidToken.Code,
+		},
+		{
+			name:          "wrong authorization scheme",
+			authorization: "Basic dXNlcjpwYXNz",
+			wantStatus:    http.StatusUnauthorized,
+			wantCode:      common.ErrInvalidToken.Code,
+		},
+		{
+			name:          "invalid signature",
+			authorization: "Bearer " + signToken(t, jwt.SigningMethodHS256, []byte("other"), "", userClaims()),
+			wantStatus:    http.StatusUnauthorized,
+			wantCode:      common.ErrInvalidToken.Code,
+		},
+		{
+			name:          "not valid yet",
+			authorization: "Bearer " + signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", notYetValid),
+			wantStatus:    http.StatusUnauthorized,
+			wantCode:      common.ErrInvalidToken.Code,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := protectedApp(middleware.NewAuthMiddleware("secret"))
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantCode, responseCode(t, resp))
+		})
+	}
+}
+
+func TestNewAuthMiddleware_TokenLookup(t *testing.T) {
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims())
+
+	tests := []struct {
+		name       string
+		lookup     string
+		setup      func(req *http.Request)
+		wantStatus int
+	}{
+		{
+			name:   "success - cookie",
+			lookup: "header:Authorization,cookie:access_token",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "success - query for websocket upgrade",
+			lookup: "query:token",
+			setup: func(req *http.Request) {
+				req.RequestURI = "/me?token=" + token
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "success - custom header",
+			lookup: "header:X-Access-Token",
+			setup: func(req *http.Request) {
+				req.Header.Set("X-Access-Token", token)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "error - query not enabled for route",
+			lookup: defaultLookup,
+			setup: func(req *http.Request) {
+				req.RequestURI = "/me?token=" + token
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := protectedApp(middleware.NewAuthMiddleware("secret", middleware.WithTokenLookup(tt.lookup)))
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			tt.setup(req)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+
+	assert.Panics(t, func() { middleware.NewAuthMiddleware("secret", middleware.WithTokenLookup("body:token")) })
+}
+
+func TestNewAuthMiddleware_Claims(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []middleware.AuthOption
+		claims     *types.JWTClaims
+		wantStatus int
+	}{
+		{
+			name:       "success - matching issuer and audience",
+			opts:       []middleware.AuthOption{middleware.WithIssuer("auth"), middleware.WithAudience("backoffice", "reporting")},
+			claims:     userClaims(func(c *types.JWTClaims) { c.Issuer = "auth"; c.Audience = jwt.ClaimStrings{"reporting"} }),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "error - wrong issuer",
+			opts:       []middleware.AuthOption{middleware.WithIssuer("auth")},
+			claims:     userClaims(func(c *types.JWTClaims) { c.Issuer = "someone-else" }),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "error - missing audience",
+			opts:       []middleware.AuthOption{middleware.WithAudience("backoffice")},
+			claims:     userClaims(),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "success - expired within leeway",
+			opts: []middleware.AuthOption{middleware.WithLeeway(time.Minute)},
+			claims: userClaims(func(c *types.JWTClaims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-30 * time.Second))
+			}),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "error - expired beyond leeway",
+			opts: []middleware.AuthOption{middleware.WithLeeway(time.Minute)},
+			claims: userClaims(func(c *types.JWTClaims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Minute))
+			}),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := protectedApp(middleware.NewAuthMiddleware("secret", tt.opts...))
+			resp := doRequest(t, app, signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", tt.claims))
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestNewAuthMiddleware_JWKS(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := jwkstest.NewServer(map[string]crypto.PublicKey{"old": &oldKey.PublicKey})
+	defer server.Close()
+
+	app := protectedApp(middleware.NewAuthMiddleware("", middleware.WithJWKS(server.URL, time.Hour)))
+
+	resp := doRequest(t, app, signToken(t, jwt.SigningMethodRS256, oldKey, "old", userClaims()))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Rotation: publishing a new kid is picked up without restarting the middleware
+	server.AddKey("new", &newKey.PublicKey)
+	resp = doRequest(t, app, signToken(t, jwt.SigningMethodES256, newKey, "new", userClaims()))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Keys that were never published are rejected
+	resp = doRequest(t, app, signToken(t, jwt.SigningMethodES256, newKey, "unknown", userClaims()))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestNewAuthMiddleware_JWKSShared(t *testing.T) {
+	jwksKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	staticKey, staticPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	defaultKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := jwkstest.NewServer(map[string]crypto.PublicKey{"jwks": &jwksKey.PublicKey})
+	defer server.Close()
+	var fetches atomic.Int32
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		handler.ServeHTTP(w, r)
+	})
+	t.Cleanup(middleware.CloseJWKS)
+
+	opts := []middleware.AuthOption{
+		middleware.WithJWKS(server.URL, time.Hour),
+		middleware.WithPublicKeyID("static", staticKey),
+		middleware.WithPublicKey(&defaultKey.PublicKey),
+	}
+	first := protectedApp(middleware.NewAuthMiddleware("secret", opts...))
+	second := protectedApp(middleware.NewAuthMiddleware("secret", opts...))
+	assert.Equal(t, int32(1), fetches.Load(), "middlewares share the key set of a URL")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "success - JWKS key", token: signToken(t, jwt.SigningMethodRS256, jwksKey, "jwks", userClaims()), wantStatus: http.StatusOK},
+		{name: "success - secret alongside JWKS", token: signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims()), wantStatus: http.StatusOK},
+		{name: "success - static key alongside JWKS", token: signToken(t, jwt.SigningMethodEdDSA, staticPriv, "static", userClaims()), wantStatus: http.StatusOK},
+		{name: "error - algorithm not accepted for the static key", token: signToken(t, jwt.SigningMethodES256, otherKey, "static", userClaims()), wantStatus: http.StatusUnauthorized},
+		{name: "success - default public key without kid alongside JWKS", token: signToken(t, jwt.SigningMethodES256, defaultKey, "", userClaims()), wantStatus: http.StatusOK},
+		{name: "error - unknown key without kid", token: signToken(t, jwt.SigningMethodES256, otherKey, "", userClaims()), wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStatus, doRequest(t, first, tt.token).StatusCode)
+			assert.Equal(t, tt.wantStatus, doRequest(t, second, tt.token).StatusCode)
+		})
+	}
+	assert.Equal(t, int32(1), fetches.Load(), "tokens without kid do not refresh the key set")
+}
+
+func TestNewAuthMiddlewareSignature(t *testing.T) {
+	claims := &types.JWTClaimsSignature{
+		ClientId: "client-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	app := protectedApp(middleware.NewAuthMiddlewareSignature("secret"))
+	resp := doRequest(t, app, signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", claims))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewAuthMiddleware_InvalidConfig(t *testing.T) {
+	assert.Panics(t, func() { middleware.NewAuthMiddleware("") })
+	assert.Panics(t, func() { middleware.NewAuthMiddleware("secret", middleware.WithStaticSecrets("static")) })
+	assert.Panics(t, func() { middleware.NewAuthMiddlewareSignature("secret", middleware.WithStaticSecrets("static")) })
+}
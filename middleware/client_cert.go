@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrCertificateNotMapped is returned by a CertificateResolver when the certificate maps to no client
+var ErrCertificateNotMapped = errors.New("client certificate is not mapped to a client")
+
+// CertificateResolver maps a verified client certificate to an integrator client id
+type CertificateResolver interface {
+	ResolveCertificate(ctx context.Context, cert *x509.Certificate) (string, error)
+}
+
+// CertificateResolverFunc adapts a function to a CertificateResolver
+type CertificateResolverFunc func(ctx context.Context, cert *x509.Certificate) (string, error)
+
+// ResolveCertificate implements CertificateResolver
+func (f CertificateResolverFunc) ResolveCertificate(ctx context.Context, cert *x509.Certificate) (string, error) {
+	return f(ctx, cert)
+}
+
+// CertificateIdentityResolver maps certificate identities to client ids with a static table.
+// Identities are checked in order: URI SANs, DNS SANs, email SANs, then the subject common name.
+type CertificateIdentityResolver map[string]string
+
+// ResolveCertificate implements CertificateResolver
+func (r CertificateIdentityResolver) ResolveCertificate(_ context.Context, cert *x509.Certificate) (string, error) {
+	for _, identity := range certificateIdentities(cert) {
+		if clientID, ok := r[identity]; ok {
+			return clientID, nil
+		}
+	}
+
+	return "", ErrCertificateNotMapped
+}
+
+func certificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+
+	return identities
+}
+
+// NewClientCertificateMiddleware : Initialize new instance for client certificate (mTLS) authentication middleware.
+// The server must verify client certificates, e.g. tls.Config ClientAuth set to tls.RequireAndVerifyClientCert,
+// only certificates of a verified chain are accepted. The resolved client id is stored like a
+// JWTClaimsSignature token, so contek.GetClientContext and contek.GetPrincipal work unchanged.
+func NewClientCertificateMiddleware(resolver CertificateResolver) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		state := c.Context().TLSConnectionState()
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			slog.Debug("Client certificate missing or not verified", "path", c.Path())
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		}
+
+		cert := state.VerifiedChains[0][0]
+		clientID, err := resolver.ResolveCertificate(c.Context(), cert)
+		if errors.Is(err, ErrCertificateNotMapped) || (err == nil && clientID == "") {
+			slog.Warn("Client certificate not mapped", "subject", cert.Subject.String(), "serial", cert.SerialNumber.String())
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		}
+		if err != nil {
+			slog.Warn("Client certificate lookup failed", "subject", cert.Subject.String(), "error", err.Error())
+			return common.Response().SetError(common.ErrServerError).Send(c)
+		}
+
+		claims := &types.JWTClaimsSignature{ClientId: clientID}
+		claims.Subject = clientID
+		c.Locals("user", &jwt.Token{Claims: claims, Valid: true})
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,210 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for the mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveMTLS serves app on a local TLS listener verifying client certificates issued by ca
+func serveMTLS(t *testing.T, app *fiber.App, ca *testCA, clientAuth tls.ClientAuthType) string {
+	t.Helper()
+
+	serverCert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   clientAuth,
+	})
+	require.NoError(t, err)
+
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	return "https://" + ln.Addr().String()
+}
+
+func mtlsClient(ca *testCA, certs ...tls.Certificate) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.pool, Certificates: certs},
+		},
+	}
+}
+
+func TestNewClientCertificateMiddleware(t *testing.T) {
+	ca := newTestCA(t)
+	spiffeID, err := url.Parse("spiffe://aggregator/provider/pragmatic")
+	require.NoError(t, err)
+
+	resolver := middleware.CertificateIdentityResolver{
+		"spiffe://aggregator/provider/pragmatic": "provider-pragmatic",
+		"pgsoft.provider.internal":               "provider-pgsoft",
+		"legacy-provider":                        "provider-legacy",
+	}
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/wallet/balance", middleware.NewClientCertificateMiddleware(resolver), func(c *fiber.Ctx) error {
+		claims := contek.GetClientContext(c.Context())
+		principal := contek.GetPrincipal(c.Context())
+		return c.SendString(claims.ClientId + "|" + principal.PrincipalID())
+	})
+	baseURL := serveMTLS(t, app, ca, tls.VerifyClientCertIfGiven)
+
+	otherCA := newTestCA(t)
+
+	tests := []struct {
+		name          string
+		certs         []tls.Certificate
+		wantStatus    int
+		wantBody      string
+		wantErrorCode int
+	}{
+		{
+			name:       "success - URI SAN",
+			certs:      []tls.Certificate{ca.issue(t, &x509.Certificate{URIs: []*url.URL{spiffeID}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})},
+			wantStatus: http.StatusOK,
+			wantBody:   "provider-pragmatic|provider-pragmatic",
+		},
+		{
+			name:       "success - DNS SAN",
+			certs:      []tls.Certificate{ca.issue(t, &x509.Certificate{DNSNames: []string{"pgsoft.provider.internal"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})},
+			wantStatus: http.StatusOK,
+			wantBody:   "provider-pgsoft|provider-pgsoft",
+		},
+		{
+			name:       "success - subject common name",
+			certs:      []tls.Certificate{ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "legacy-provider"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})},
+			wantStatus: http.StatusOK,
+			wantBody:   "provider-legacy|provider-legacy",
+		},
+		{
+			name:          "error - unmapped certificate",
+			certs:         []tls.Certificate{ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})},
+			wantStatus:    http.StatusUnauthorized,
+			wantErrorCode: common.ErrUnauthorized.Code,
+		},
+		{
+			name:          "error - no client certificate",
+			wantStatus:    http.StatusUnauthorized,
+			wantErrorCode: common.ErrUnauthorized.Code,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := mtlsClient(ca, tt.certs...).Get(baseURL + "/wallet/balance")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantErrorCode != 0 {
+				assert.Equal(t, tt.wantErrorCode, responseCode(t, resp))
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+
+	t.Run("error - certificate from untrusted CA is rejected by the handshake", func(t *testing.T) {
+		cert := otherCA.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "legacy-provider"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		_, err := mtlsClient(ca, cert).Get(baseURL + "/wallet/balance")
+		assert.Error(t, err)
+	})
+}
+
+func TestNewClientCertificateMiddleware_ResolverError(t *testing.T) {
+	ca := newTestCA(t)
+	resolver := middleware.CertificateResolverFunc(func(context.Context, *x509.Certificate) (string, error) {
+		return "", errors.New("database unavailable")
+	})
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/wallet/balance", middleware.NewClientCertificateMiddleware(resolver), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+	baseURL := serveMTLS(t, app, ca, tls.RequireAndVerifyClientCert)
+
+	cert := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "provider"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	resp, err := mtlsClient(ca, cert).Get(baseURL + "/wallet/balance")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, common.ErrServerError.Code, responseCode(t, resp))
+}
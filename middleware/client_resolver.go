@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+)
+
+// ErrClientNotFound is returned by a ClientResolver when no integrator client matches the id
+var ErrClientNotFound = errors.New("integrator client not found")
+
+// ClientResolver loads integrator clients, e.g. from Valkey or the integrator database
+type ClientResolver interface {
+	ResolveClient(ctx context.Context, clientID string) (*types.IntegratorClient, error)
+}
+
+// WithClientResolver : Reject integrator clients that are unknown or not active.
+// The resolved client is stored in Locals "client", see contek.GetIntegratorClient,
+// and its secret verifies v2 signatures. It is required to accept v2 signatures.
+func WithClientResolver(resolver ClientResolver) AggregatorOption {
+	return func(cfg *aggregatorConfig) {
+		cfg.clientResolver = resolver
+	}
+}
+
+// MemoryClientResolver is an in-memory ClientResolver, suitable for tests
+type MemoryClientResolver struct {
+	mu      sync.RWMutex
+	clients map[string]types.IntegratorClient
+}
+
+// NewMemoryClientResolver : Initialize new in-memory client resolver holding the given clients
+func NewMemoryClientResolver(clients ...types.IntegratorClient) *MemoryClientResolver {
+	r := &MemoryClientResolver{clients: make(map[string]types.IntegratorClient, len(clients))}
+	for _, client := range clients {
+		r.clients[client.ID] = client
+	}
+
+	return r
+}
+
+// Set adds or replaces a client
+func (r *MemoryClientResolver) Set(client types.IntegratorClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.ID] = client
+}
+
+// Delete removes a client
+func (r *MemoryClientResolver) Delete(clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, clientID)
+}
+
+// ResolveClient implements ClientResolver
+func (r *MemoryClientResolver) ResolveClient(_ context.Context, clientID string) (*types.IntegratorClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+
+	return &client, nil
+}
+
+// CachedClientResolver caches the clients returned by another resolver in-process.
+// Unknown clients are cached for negativeTTL, other errors are not cached.
+type CachedClientResolver struct {
+	next        ClientResolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedClient
+}
+
+type cachedClient struct {
+	client    *types.IntegratorClient
+	expiresAt time.Time
+}
+
+// NewCachedClientResolver : Initialize new caching resolver in front of next
+func NewCachedClientResolver(next ClientResolver, ttl, negativeTTL time.Duration) *CachedClientResolver {
+	return &CachedClientResolver{
+		next:        next,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]cachedClient),
+	}
+}
+
+// ResolveClient implements ClientResolver
+func (r *CachedClientResolver) ResolveClient(ctx context.Context, clientID string) (*types.IntegratorClient, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	entry, ok := r.entries[clientID]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		if entry.client == nil {
+			return nil, ErrClientNotFound
+		}
+		return entry.client, nil
+	}
+
+	client, err := r.next.ResolveClient(ctx, clientID)
+	switch {
+	case errors.Is(err, ErrClientNotFound):
+		r.store(clientID, cachedClient{expiresAt: now.Add(r.negativeTTL)})
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	r.store(clientID, cachedClient{client: client, expiresAt: now.Add(r.ttl)})
+	return client, nil
+}
+
+// Invalidate drops the cached entry of a client, e.g. after its status or secret changed
+func (r *CachedClientResolver) Invalidate(clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, clientID)
+}
+
+func (r *CachedClientResolver) store(clientID string, entry cachedClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, e := range r.entries {
+		if !now.Before(e.expiresAt) {
+			delete(r.entries, id)
+		}
+	}
+	r.entries[clientID] = entry
+}
@@ -0,0 +1,123 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingResolver counts lookups reaching the underlying resolver
+type countingResolver struct {
+	next  middleware.ClientResolver
+	calls atomic.Int32
+	err   error
+}
+
+func (r *countingResolver) ResolveClient(ctx context.Context, clientID string) (*types.IntegratorClient, error) {
+	r.calls.Add(1)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.next.ResolveClient(ctx, clientID)
+}
+
+func TestCachedClientResolver(t *testing.T) {
+	ctx := context.Background()
+	memory := middleware.NewMemoryClientResolver(types.IntegratorClient{ID: "client-1", Status: types.Active})
+	counting := &countingResolver{next: memory}
+	cached := middleware.NewCachedClientResolver(counting, 50*time.Millisecond, 20*time.Millisecond)
+
+	client, err := cached.ResolveClient(ctx, "client-1")
+	require.NoError(t, err)
+	assert.Equal(t, "client-1", client.ID)
+	_, _ = cached.ResolveClient(ctx, "client-1")
+	assert.Equal(t, int32(1), counting.calls.Load())
+
+	// Changes are picked up once the entry expires or is invalidated
+	memory.Set(types.IntegratorClient{ID: "client-1", Status: types.Inactive})
+	cached.Invalidate("client-1")
+	client, err = cached.ResolveClient(ctx, "client-1")
+	require.NoError(t, err)
+	assert.Equal(t, types.Inactive, client.Status)
+	assert.Equal(t, int32(2), counting.calls.Load())
+
+	// Unknown clients are cached for the negative ttl
+	_, err = cached.ResolveClient(ctx, "unknown")
+	assert.ErrorIs(t, err, middleware.ErrClientNotFound)
+	_, err = cached.ResolveClient(ctx, "unknown")
+	assert.ErrorIs(t, err, middleware.ErrClientNotFound)
+	assert.Equal(t, int32(3), counting.calls.Load())
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = cached.ResolveClient(ctx, "unknown")
+	assert.Equal(t, int32(4), counting.calls.Load())
+
+	// Lookup failures are not cached
+	failing := &countingResolver{err: errors.New("valkey unavailable")}
+	cached = middleware.NewCachedClientResolver(failing, time.Minute, time.Minute)
+	_, err = cached.ResolveClient(ctx, "client-1")
+	assert.Error(t, err)
+	_, _ = cached.ResolveClient(ctx, "client-1")
+	assert.Equal(t, int32(2), failing.calls.Load())
+}
+
+func TestValidateAggregatorSignature_ClientResolver(t *testing.T) {
+	issuer, err := middleware.NewIssuer(middleware.IssuerConfig{Secret: "secret"})
+	require.NoError(t, err)
+
+	resolver := middleware.NewMemoryClientResolver(
+		types.IntegratorClient{ID: "active", Status: types.Active, Secret: "resolved-secret", Namespace: "operator-ns"},
+		types.IntegratorClient{ID: "inactive", Status: types.Inactive, Secret: "resolved-secret"},
+	)
+
+	app := fiber.New()
+	app.Post("/wallet/debit",
+		middleware.NewAuthMiddlewareSignature("secret"),
+		middleware.ValidateAggregatorSignature(middleware.WithClientResolver(resolver)),
+		func(c *fiber.Ctx) error {
+			return c.SendString(contek.GetIntegratorClient(c.Context()).Namespace)
+		},
+	)
+
+	tests := []struct {
+		name       string
+		clientID   string
+		secret     string
+		wantStatus int
+		wantCode   int
+	}{
+		{name: "success - active client signs with resolved secret", clientID: "active", secret: "resolved-secret", wantStatus: http.StatusOK},
+		{name: "error - token secret is never used", clientID: "active", secret: clientSecret, wantStatus: http.StatusBadRequest, wantCode: common.ErrInvalidSignature.Code},
+		{name: "error - inactive client", clientID: "inactive", secret: "resolved-secret", wantStatus: http.StatusUnauthorized, wantCode: common.ErrUnauthorized.Code},
+		{name: "error - unknown client", clientID: "unknown", secret: clientSecret, wantStatus: http.StatusUnauthorized, wantCode: common.ErrUnauthorized.Code},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := issuer.IssueClientToken(types.JWTClaimsSignature{ClientId: tt.clientID, ClientSecret: clientSecret})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/wallet/debit", nil)
+			require.NoError(t, middleware.NewSigner(token, tt.secret).SignHTTPRequest(req))
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuthzRequest is the input of an authorization decision
+type AuthzRequest struct {
+	Principal types.Principal
+	Action    types.PermissionAction
+	// Resource is the permission protecting the resource, e.g. types.PermissionGames
+	Resource types.Permission
+	// Attributes describe the request and the resource, e.g. "namespace" or "locked", see SetAuthzAttribute
+	Attributes map[string]any
+}
+
+// Decision is the outcome of an authorization decision, Reason is meant for logs and audits
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+
+	// stepUp marks a denial that a more recent authentication would lift
+	stepUp bool
+	// checks are the permission checks behind the decision, recorded in audit events of Requirement handlers
+	checks []permissionCheck
+}
+
+// permissionCheck is an action evaluated on a permission
+type permissionCheck struct {
+	permission types.Permission
+	action     types.PermissionAction
+}
+
+// PolicyEvaluator decides whether a principal may perform an action on a resource.
+// An error means no decision could be made, callers must deny the request.
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, req AuthzRequest) (Decision, error)
+}
+
+// PolicyEvaluatorFunc adapts a function to a PolicyEvaluator
+type PolicyEvaluatorFunc func(ctx context.Context, req AuthzRequest) (Decision, error)
+
+// Evaluate implements PolicyEvaluator
+func (f PolicyEvaluatorFunc) Evaluate(ctx context.Context, req AuthzRequest) (Decision, error) {
+	return f(ctx, req)
+}
+
+// BitmaskEvaluator grants an action when the effective permissions of the principal,
+// see types.Principal PrincipalPermissions, include it. It is the default evaluator.
+type BitmaskEvaluator struct{}
+
+// Evaluate implements PolicyEvaluator
+func (BitmaskEvaluator) Evaluate(_ context.Context, req AuthzRequest) (Decision, error) {
+	if req.Principal == nil {
+		return Decision{Reason: "no principal"}, nil
+	}
+
+	perms := req.Principal.PrincipalPermissions()
+	if perms.Has(req.Resource, req.Action) {
+		return Decision{Allowed: true, Reason: fmt.Sprintf("%s grants %v", req.Resource, req.Action.Names())}, nil
+	}
+
+	return Decision{Reason: fmt.Sprintf("%s does not grant %v, have %v", req.Resource, req.Action.Names(), perms.Get(req.Resource).Names())}, nil
+}
+
+// DefaultPolicyEvaluator is used by ValidatePermission, EnforcePolicy, RequirePermission, RequireAction and PermissionsHandler.
+// It is read when they are created, not per request, so it must be set before. Pass an evaluator to their
+// "With" variants, e.g. ValidatePermissionWith, instead of changing it once requests are served.
+var DefaultPolicyEvaluator PolicyEvaluator = BitmaskEvaluator{}
+
+// mustEvaluator panics when evaluator is nil
+func mustEvaluator(evaluator PolicyEvaluator, caller string) {
+	if evaluator == nil {
+		panic(caller + ": policy evaluator is required")
+	}
+}
+
+// SetAuthzAttribute : Add an attribute to the authorization requests of the current request,
+// e.g. from a middleware loading the resource before ValidatePermission
+func SetAuthzAttribute(c *fiber.Ctx, key string, value any) {
+	attrs, _ := c.Locals("authz_attributes").(map[string]any)
+	if attrs == nil {
+		attrs = make(map[string]any)
+		c.Locals("authz_attributes", attrs)
+	}
+	attrs[key] = value
+}
+
+// authzAttributes returns the request attributes: "method", "path", "tenant" when scoped,
+// "param.<name>" for route params and the attributes set with SetAuthzAttribute
+func authzAttributes(c *fiber.Ctx) map[string]any {
+	attrs := map[string]any{
+		"method": c.Method(),
+		"path":   c.Path(),
+	}
+	if tenant, ok := c.Locals("tenant").(string); ok {
+		attrs["tenant"] = tenant
+	}
+	for name, value := range c.AllParams() {
+		attrs["param."+name] = value
+	}
+	if custom, ok := c.Locals("authz_attributes").(map[string]any); ok {
+		for key, value := range custom {
+			attrs[key] = value
+		}
+	}
+
+	return attrs
+}
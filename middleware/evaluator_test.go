@@ -0,0 +1,262 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const agentRules = `{
+	"rules": [
+		{
+			"name": "agents edit players in their namespace only",
+			"effect": "deny",
+			"permission": "agent",
+			"actions": ["write", "delete"],
+			"condition": "principal.role == 'user' && (attr.namespace != principal.namespace || attr.locked == true)"
+		},
+		{
+			"name": "support reads every report",
+			"effect": "allow",
+			"actions": ["read"],
+			"condition": "principal.id in ['support-1', 'support-2'] && permission != 'settings'"
+		}
+	]
+}`
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestBitmaskEvaluator(t *testing.T) {
+	claims := userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{types.PermissionGames: types.ActionRead}
+	})
+
+	decision, err := middleware.BitmaskEvaluator{}.Evaluate(context.Background(), middleware.AuthzRequest{
+		Principal: claims, Action: types.ActionRead, Resource: types.PermissionGames,
+	})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "games grants [read]", decision.Reason)
+
+	decision, err = middleware.BitmaskEvaluator{}.Evaluate(context.Background(), middleware.AuthzRequest{
+		Principal: claims, Action: types.ActionWrite, Resource: types.PermissionGames,
+	})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "games does not grant [write], have [read]", decision.Reason)
+}
+
+func TestExpressionEvaluator(t *testing.T) {
+	rules, err := middleware.LoadExpressionRules(writeRules(t, agentRules))
+	require.NoError(t, err)
+	evaluator, err := middleware.NewExpressionEvaluator(rules, middleware.BitmaskEvaluator{})
+	require.NoError(t, err)
+
+	agent := userClaims(func(c *types.JWTClaims) {
+		c.Type = types.RoleUser
+		c.Namespace = "agent-ns"
+		c.Permissions = types.PermissionsDTO{types.PermissionAgent: types.ActionAll}
+	})
+	support := userClaims(func(c *types.JWTClaims) { c.ID = "support-2" })
+
+	tests := []struct {
+		name        string
+		req         middleware.AuthzRequest
+		wantAllowed bool
+		wantReason  string
+	}{
+		{
+			name:        "success - own namespace falls back to the bitmask",
+			req:         middleware.AuthzRequest{Principal: agent, Action: types.ActionWrite, Resource: types.PermissionAgent, Attributes: map[string]any{"namespace": "agent-ns", "locked": false}},
+			wantAllowed: true,
+			wantReason:  "agent grants [write]",
+		},
+		{
+			name:       "error - other namespace",
+			req:        middleware.AuthzRequest{Principal: agent, Action: types.ActionWrite, Resource: types.PermissionAgent, Attributes: map[string]any{"namespace": "other-ns"}},
+			wantReason: "denied by rule agents edit players in their namespace only",
+		},
+		{
+			name:       "error - locked player",
+			req:        middleware.AuthzRequest{Principal: agent, Action: types.ActionDelete, Resource: types.PermissionAgent, Attributes: map[string]any{"namespace": "agent-ns", "locked": true}},
+			wantReason: "denied by rule agents edit players in their namespace only",
+		},
+		{
+			name:        "success - read is not restricted by the deny rule",
+			req:         middleware.AuthzRequest{Principal: agent, Action: types.ActionRead, Resource: types.PermissionAgent, Attributes: map[string]any{"namespace": "other-ns"}},
+			wantAllowed: true,
+			wantReason:  "agent grants [read]",
+		},
+		{
+			name:        "success - allow rule without bitmask grant",
+			req:         middleware.AuthzRequest{Principal: support, Action: types.ActionRead, Resource: types.PermissionReportProfit},
+			wantAllowed: true,
+			wantReason:  "allowed by rule support reads every report",
+		},
+		{
+			name:       "error - allow rule condition not met",
+			req:        middleware.AuthzRequest{Principal: support, Action: types.ActionRead, Resource: types.PermissionSettings},
+			wantReason: "settings does not grant [read], have []",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := evaluator.Evaluate(context.Background(), tt.req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAllowed, decision.Allowed)
+			assert.Equal(t, tt.wantReason, decision.Reason)
+		})
+	}
+
+	withoutFallback, err := middleware.NewExpressionEvaluator(rules, nil)
+	require.NoError(t, err)
+	decision, err := withoutFallback.Evaluate(context.Background(), middleware.AuthzRequest{Principal: agent, Action: types.ActionRead, Resource: types.PermissionGames})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "no rule matched", decision.Reason)
+}
+
+func TestNewExpressionEvaluator_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    middleware.ExpressionRule
+		wantErr string
+	}{
+		{name: "error - effect", rule: middleware.ExpressionRule{Name: "r", Effect: "maybe"}, wantErr: "effect must be allow or deny"},
+		{name: "error - action", rule: middleware.ExpressionRule{Name: "r", Effect: "allow", Actions: []string{"fly"}}, wantErr: "unknown action"},
+		{name: "error - unknown identifier", rule: middleware.ExpressionRule{Name: "r", Effect: "allow", Condition: "principal.nmespace == 'x'"}, wantErr: "unknown identifier"},
+		{name: "error - unbalanced parenthesis", rule: middleware.ExpressionRule{Name: "r", Effect: "allow", Condition: "(attr.a == 1"}, wantErr: "missing )"},
+		{name: "error - unterminated string", rule: middleware.ExpressionRule{Name: "r", Effect: "allow", Condition: "attr.a == 'x"}, wantErr: "unterminated string"},
+		{name: "error - trailing tokens", rule: middleware.ExpressionRule{Name: "r", Effect: "allow", Condition: "attr.a == 1 attr.b"}, wantErr: "unexpected"},
+		{name: "error - list without commas", rule: middleware.ExpressionRule{Name: "r", Effect: "allow", Condition: "attr.a in [1 2]"}, wantErr: "missing ,"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := middleware.NewExpressionEvaluator([]middleware.ExpressionRule{tt.rule}, nil)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	_, err := middleware.LoadExpressionRules(writeRules(t, "{"))
+	assert.ErrorContains(t, err, "cannot parse rules file")
+}
+
+func TestValidatePermissionWith(t *testing.T) {
+	rules, err := middleware.LoadExpressionRules(writeRules(t, agentRules))
+	require.NoError(t, err)
+	evaluator, err := middleware.NewExpressionEvaluator(rules, middleware.BitmaskEvaluator{})
+	require.NoError(t, err)
+
+	// loadPlayer stands in for a middleware loading the player before the permission check
+	loadPlayer := func(c *fiber.Ctx) error {
+		middleware.SetAuthzAttribute(c, "namespace", c.Query("player_namespace"))
+		middleware.SetAuthzAttribute(c, "locked", c.QueryBool("locked"))
+		return c.Next()
+	}
+	failing := middleware.PolicyEvaluatorFunc(func(context.Context, middleware.AuthzRequest) (middleware.Decision, error) {
+		return middleware.Decision{}, errors.New("policy store unavailable")
+	})
+
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	app.Put("/players/:id", middleware.NewAuthMiddleware("secret"), loadPlayer, middleware.ValidatePermissionWith(evaluator, types.PermissionAgent), ok)
+	app.Get("/players/:id", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermissionWith(failing, types.PermissionAgent), ok)
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Type = types.RoleUser
+		c.Namespace = "agent-ns"
+		c.Permissions = types.PermissionsDTO{types.PermissionAgent: types.ActionAll}
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantCode   int
+	}{
+		{name: "success - own unlocked player", method: http.MethodPut, target: "/players/1?player_namespace=agent-ns", wantStatus: http.StatusOK},
+		{name: "error - player in another namespace", method: http.MethodPut, target: "/players/1?player_namespace=other-ns", wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "error - locked player", method: http.MethodPut, target: "/players/1?player_namespace=agent-ns&locked=true", wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "error - evaluator failure", method: http.MethodGet, target: "/players/1", wantStatus: http.StatusInternalServerError, wantCode: common.ErrServerError.Code},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RequestURI = tt.target
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			}
+		})
+	}
+}
+
+func TestEnforcePolicyWith(t *testing.T) {
+	denyAll := middleware.PolicyEvaluatorFunc(func(context.Context, middleware.AuthzRequest) (middleware.Decision, error) {
+		return middleware.Decision{Reason: "denied by policy store"}, nil
+	})
+	failing := middleware.PolicyEvaluatorFunc(func(context.Context, middleware.AuthzRequest) (middleware.Decision, error) {
+		return middleware.Decision{}, errors.New("policy store unavailable")
+	})
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{types.PermissionGames: types.ActionRead}
+	}))
+
+	tests := []struct {
+		name       string
+		evaluator  middleware.PolicyEvaluator
+		wantStatus int
+		wantCode   int
+	}{
+		{name: "success - bitmask evaluator", evaluator: middleware.BitmaskEvaluator{}, wantStatus: http.StatusOK},
+		{name: "error - custom evaluator denies", evaluator: denyAll, wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "error - evaluator failure", evaluator: failing, wantStatus: http.StatusInternalServerError, wantCode: common.ErrServerError.Code},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(middleware.NewAuthMiddleware("secret"), middleware.EnforcePolicyWith(tt.evaluator, testPolicyTable()))
+			app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/games/42", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			}
+		})
+	}
+
+	assert.Panics(t, func() { middleware.EnforcePolicyWith(nil, testPolicyTable()) })
+}
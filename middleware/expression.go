@@ -0,0 +1,446 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+)
+
+// ExpressionRule is an authorization rule of the ExpressionEvaluator.
+// The rule applies to requests on Permission (any when empty) for one of Actions (any when empty)
+// whose Condition evaluates to true.
+//
+// Conditions support &&, ||, !, ==, !=, "in" with a list, parentheses, 'string' or "string" literals,
+// numbers, true, false and the identifiers principal.id, principal.namespace, principal.parent_namespace,
+// principal.role, principal.kind, permission, action and attr.<name> for request attributes, e.g.
+//
+//	principal.role == 'user' && (attr.namespace != principal.namespace || attr.locked == true)
+type ExpressionRule struct {
+	Name       string           `json:"name"`
+	Effect     string           `json:"effect"` // "allow" or "deny"
+	Permission types.Permission `json:"permission,omitempty"`
+	Actions    []string         `json:"actions,omitempty"`
+	Condition  string           `json:"condition,omitempty"`
+}
+
+// LoadExpressionRules : Load rules from JSON files, each holding {"rules": [...]}
+func LoadExpressionRules(paths ...string) ([]ExpressionRule, error) {
+	var rules []ExpressionRule
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var file struct {
+			Rules []ExpressionRule `json:"rules"`
+		}
+		if err := json.Unmarshal(b, &file); err != nil {
+			return nil, fmt.Errorf("cannot parse rules file %s: %w", path, err)
+		}
+		rules = append(rules, file.Rules...)
+	}
+
+	return rules, nil
+}
+
+type compiledRule struct {
+	ExpressionRule
+	deny      bool
+	actions   types.PermissionAction
+	condition expression
+}
+
+// ExpressionEvaluator evaluates expression rules. A matching deny rule wins over allow rules,
+// and requests matching no rule are delegated to the fallback evaluator, or denied without one.
+type ExpressionEvaluator struct {
+	rules    []compiledRule
+	fallback PolicyEvaluator
+}
+
+// NewExpressionEvaluator : Compile rules into an evaluator, e.g. deny rules narrowing BitmaskEvaluator as fallback
+func NewExpressionEvaluator(rules []ExpressionRule, fallback PolicyEvaluator) (*ExpressionEvaluator, error) {
+	e := &ExpressionEvaluator{fallback: fallback}
+	for _, rule := range rules {
+		compiled := compiledRule{ExpressionRule: rule}
+
+		switch strings.ToLower(rule.Effect) {
+		case "allow":
+		case "deny":
+			compiled.deny = true
+		default:
+			return nil, fmt.Errorf("rule %q: effect must be allow or deny", rule.Name)
+		}
+
+		for _, name := range rule.Actions {
+			action, ok := types.ParsePermissionAction(name)
+			if !ok {
+				return nil, fmt.Errorf("rule %q: unknown action %q", rule.Name, name)
+			}
+			compiled.actions |= action
+		}
+
+		if rule.Condition != "" {
+			condition, err := parseExpression(rule.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			compiled.condition = condition
+		}
+
+		e.rules = append(e.rules, compiled)
+	}
+
+	return e, nil
+}
+
+// Evaluate implements PolicyEvaluator
+func (e *ExpressionEvaluator) Evaluate(ctx context.Context, req AuthzRequest) (Decision, error) {
+	env := expressionEnv(req)
+
+	var allowed *compiledRule
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if rule.Permission != "" && rule.Permission != req.Resource {
+			continue
+		}
+		if rule.actions != 0 && rule.actions&req.Action == 0 {
+			continue
+		}
+		if rule.condition != nil && rule.condition.eval(env) != true {
+			continue
+		}
+
+		if rule.deny {
+			return Decision{Reason: "denied by rule " + rule.Name}, nil
+		}
+		if allowed == nil {
+			allowed = rule
+		}
+	}
+
+	if allowed != nil {
+		return Decision{Allowed: true, Reason: "allowed by rule " + allowed.Name}, nil
+	}
+	if e.fallback != nil {
+		return e.fallback.Evaluate(ctx, req)
+	}
+
+	return Decision{Reason: "no rule matched"}, nil
+}
+
+var expressionIdentifiers = map[string]bool{
+	"permission":                 true,
+	"action":                     true,
+	"principal.id":               true,
+	"principal.namespace":        true,
+	"principal.parent_namespace": true,
+	"principal.role":             true,
+	"principal.kind":             true,
+}
+
+func expressionEnv(req AuthzRequest) map[string]any {
+	env := map[string]any{
+		"permission": string(req.Resource),
+		"action":     strings.Join(req.Action.Names(), ","),
+	}
+	if p := req.Principal; p != nil {
+		env["principal.id"] = p.PrincipalID()
+		env["principal.namespace"] = p.PrincipalNamespace()
+		env["principal.parent_namespace"] = p.PrincipalParentNamespace()
+		env["principal.role"] = string(p.PrincipalRole())
+		env["principal.kind"] = string(p.PrincipalKind())
+	}
+	for key, value := range req.Attributes {
+		env["attr."+key] = value
+	}
+
+	return env
+}
+
+// expression is a compiled condition, eval returns a bool, float64, string, []any or nil
+type expression interface {
+	eval(env map[string]any) any
+}
+
+type (
+	literalExpr struct{ value any }
+	identExpr   struct{ name string }
+	listExpr    struct{ items []expression }
+	notExpr     struct{ operand expression }
+	logicalExpr struct {
+		and         bool
+		left, right expression
+	}
+	compareExpr struct {
+		op          string
+		left, right expression
+	}
+)
+
+func (e literalExpr) eval(map[string]any) any { return e.value }
+
+func (e identExpr) eval(env map[string]any) any { return normalizeValue(env[e.name]) }
+
+func (e listExpr) eval(env map[string]any) any {
+	items := make([]any, len(e.items))
+	for i, item := range e.items {
+		items[i] = item.eval(env)
+	}
+	return items
+}
+
+func (e notExpr) eval(env map[string]any) any { return e.operand.eval(env) != true }
+
+func (e logicalExpr) eval(env map[string]any) any {
+	left := e.left.eval(env) == true
+	if e.and {
+		return left && e.right.eval(env) == true
+	}
+	return left || e.right.eval(env) == true
+}
+
+func (e compareExpr) eval(env map[string]any) any {
+	left, right := e.left.eval(env), e.right.eval(env)
+	switch e.op {
+	case "==":
+		return equalValues(left, right)
+	case "!=":
+		return !equalValues(left, right)
+	default: // in
+		items, _ := right.([]any)
+		for _, item := range items {
+			if equalValues(item, left) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// normalizeValue converts attribute values to the comparable types produced by literals
+func normalizeValue(v any) any {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return v
+}
+
+// equalValues compares two values, values of uncomparable types such as maps are never equal
+func equalValues(a, b any) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// expressionParser is a recursive descent parser for rule conditions:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ( "==" | "!=" | "in" ) operand ]
+//	operand = "(" or ")" | "[" [ operand { "," operand } ] "]" | string | number | true | false | identifier
+type expressionParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseExpression(src string) (expression, error) {
+	tokens, err := tokenizeExpression(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition", p.tokens[p.pos])
+	}
+
+	return expr, nil
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *expressionParser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right expression
+		right, err = p.parseAnd()
+		left = logicalExpr{left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *expressionParser) parseAnd() (expression, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right expression
+		right, err = p.parseUnary()
+		left = logicalExpr{and: true, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.peek() == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		return notExpr{operand: operand}, err
+	}
+	return p.parseCompare()
+}
+
+func (p *expressionParser) parseCompare() (expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op := p.peek(); op {
+	case "==", "!=", "in":
+		p.next()
+		right, err := p.parseOperand()
+		return compareExpr{op: op, left: left, right: right}, err
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseOperand() (expression, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case token == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ) in condition")
+		}
+		return expr, nil
+	case token == "[":
+		var list listExpr
+		for p.peek() != "]" {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+			if p.peek() != "]" && p.next() != "," {
+				return nil, fmt.Errorf("missing , in list")
+			}
+		}
+		p.next()
+		return list, nil
+	case token[0] == '\'' || token[0] == '"':
+		return literalExpr{value: token[1 : len(token)-1]}, nil
+	case token == "true" || token == "false":
+		return literalExpr{value: token == "true"}, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '-':
+		n, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in condition", token)
+		}
+		return literalExpr{value: n}, nil
+	case isIdentifier(token):
+		if !expressionIdentifiers[token] && !strings.HasPrefix(token, "attr.") {
+			return nil, fmt.Errorf("unknown identifier %q in condition", token)
+		}
+		return identExpr{name: token}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q in condition", token)
+	}
+}
+
+func isIdentifier(token string) bool {
+	for i, r := range token {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && (unicode.IsDigit(r) || r == '.'))) {
+			return false
+		}
+	}
+	return token != "in"
+}
+
+func tokenizeExpression(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "&&"), strings.HasPrefix(src[i:], "||"),
+			strings.HasPrefix(src[i:], "=="), strings.HasPrefix(src[i:], "!="):
+			tokens = append(tokens, src[i:i+2])
+			i += 2
+		case strings.ContainsRune("!()[],", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in condition")
+			}
+			tokens = append(tokens, src[i:i+end+2])
+			i += end + 2
+		default:
+			start := i
+			for i < len(src) && !strings.ContainsRune(" \t\n\r!()[],&|='\"", rune(src[i])) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected %q in condition", c)
+			}
+			tokens = append(tokens, src[start:i])
+		}
+	}
+
+	return tokens, nil
+}
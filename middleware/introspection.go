@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PermissionIntrospection describes what the caller is allowed to do, e.g. to build the admin menus
+type PermissionIntrospection struct {
+	ID              string     `json:"id"`
+	Role            types.Role `json:"role"`
+	Namespace       string     `json:"namespace,omitempty"`
+	ParentNamespace string     `json:"parent_namespace,omitempty"`
+	// Permissions lists the granted action names of every registered permission, e.g. {"games": ["read","write"]}
+	Permissions map[types.Permission][]string `json:"permissions"`
+	ExpiresAt   *time.Time                    `json:"expires_at,omitempty"`
+}
+
+// IntrospectPermissions : Get the actions granted to claims on every registered permission,
+// each declared action is checked with evaluator as ValidatePermission would.
+// Rules depending on request attributes are evaluated against the attributes of c.
+func IntrospectPermissions(c *fiber.Ctx, evaluator PolicyEvaluator, claims *types.JWTClaims) (PermissionIntrospection, error) {
+	out := PermissionIntrospection{
+		ID:              claims.ID,
+		Role:            claims.Type,
+		Namespace:       claims.Namespace,
+		ParentNamespace: claims.ParentNamespace,
+		Permissions:     make(map[types.Permission][]string),
+	}
+	if claims.ExpiresAt != nil {
+		exp := claims.ExpiresAt.Time.UTC()
+		out.ExpiresAt = &exp
+	}
+
+	attrs := authzAttributes(c)
+	for _, def := range types.RegisteredPermissions() {
+		var granted types.PermissionAction
+		for action := types.PermissionAction(1); action != 0 && action <= def.Actions; action <<= 1 {
+			if def.Actions&action == 0 {
+				continue
+			}
+
+			decision, err := evaluator.Evaluate(c.Context(), AuthzRequest{Principal: claims, Action: action, Resource: def.Key, Attributes: attrs})
+			if err != nil {
+				return PermissionIntrospection{}, err
+			}
+			if decision.Allowed {
+				granted |= action
+			}
+		}
+		out.Permissions[def.Key] = granted.Names()
+	}
+
+	return out, nil
+}
+
+// PermissionsHandler : Handler returning the PermissionIntrospection of the caller, checked with DefaultPolicyEvaluator.
+// Mount it after the authentication middleware, e.g. app.Get("/me/permissions", auth, PermissionsHandler()).
+func PermissionsHandler() fiber.Handler {
+	return PermissionsHandlerWith(DefaultPolicyEvaluator)
+}
+
+// PermissionsHandlerWith : Same as PermissionsHandler, permissions are checked with evaluator,
+// e.g. the evaluator given to ValidatePermissionWith so both agree
+func PermissionsHandlerWith(evaluator PolicyEvaluator) fiber.Handler {
+	mustEvaluator(evaluator, "PermissionsHandlerWith")
+
+	return func(c *fiber.Ctx) error {
+		claims := contek.GetUserContext(c.Context())
+		if claims == nil {
+			slog.Warn("PermissionsHandler: JWT claims not found in Locals")
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		}
+
+		out, err := IntrospectPermissions(c, evaluator, claims)
+		if err != nil {
+			slog.Warn("Permission introspection failed", "userID", claims.ID, "error", err.Error())
+			return common.Response().SetError(common.ErrServerError).Send(c)
+		}
+
+		return common.Response().SetData(out).Send(c)
+	}
+}
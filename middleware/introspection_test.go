@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsHandler(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Type = types.RoleUser
+		c.ParentNamespace = "parent-ns"
+		c.ExpiresAt = jwt.NewNumericDate(exp)
+		c.Permissions = types.PermissionsDTO{
+			types.PermissionGames: types.ActionRead | types.ActionWrite,
+			types.PermissionAgent: types.ActionDelete,
+		}
+	}))
+
+	app := fiber.New()
+	app.Get("/me/permissions", middleware.NewAuthMiddleware("secret"), middleware.PermissionsHandler())
+	app.Get("/anonymous/permissions", middleware.PermissionsHandler())
+
+	t.Run("success - effective permissions", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/me/permissions", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var payload struct {
+			Data middleware.PermissionIntrospection `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &payload))
+
+		got := payload.Data
+		assert.Equal(t, "user-1", got.ID)
+		assert.Equal(t, types.RoleUser, got.Role)
+		assert.Equal(t, "agent-ns", got.Namespace)
+		assert.Equal(t, "parent-ns", got.ParentNamespace)
+		require.NotNil(t, got.ExpiresAt)
+		assert.True(t, exp.Equal(*got.ExpiresAt))
+		assert.Equal(t, []string{"read", "write"}, got.Permissions[types.PermissionGames])
+		assert.Equal(t, []string{"delete"}, got.Permissions[types.PermissionAgent])
+		assert.Equal(t, []string{}, got.Permissions[types.PermissionSettings])
+		assert.Len(t, got.Permissions, len(types.RegisteredPermissions()))
+	})
+
+	t.Run("error - unauthenticated", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/anonymous/permissions", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, common.ErrUnauthorized.Code, responseCode(t, resp))
+	})
+
+	t.Run("error - evaluator failure", func(t *testing.T) {
+		failing := fiber.New()
+		failing.Get("/me/permissions", middleware.NewAuthMiddleware("secret"), middleware.PermissionsHandlerWith(
+			middleware.PolicyEvaluatorFunc(func(context.Context, middleware.AuthzRequest) (middleware.Decision, error) {
+				return middleware.Decision{}, errors.New("policy store unavailable")
+			}),
+		))
+
+		req := httptest.NewRequest(http.MethodGet, "/me/permissions", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		resp, err := failing.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, common.ErrServerError.Code, responseCode(t, resp))
+	})
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// IPAllowlistOption configures ValidateClientIP
+type IPAllowlistOption func(*ipAllowlistConfig)
+
+type ipAllowlistConfig struct {
+	trustedProxies   []netip.Prefix
+	requireAllowlist bool
+}
+
+// WithTrustedProxies : Read the caller IP from X-Forwarded-For when the request comes from one of the given IPs or CIDR ranges.
+// The caller IP is the right-most X-Forwarded-For entry that is not a trusted proxy.
+func WithTrustedProxies(proxies ...string) IPAllowlistOption {
+	return func(cfg *ipAllowlistConfig) {
+		for _, proxy := range proxies {
+			prefix, err := parsePrefix(proxy)
+			if err != nil {
+				panic("ip allowlist configuration: invalid trusted proxy " + proxy)
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, prefix)
+		}
+	}
+}
+
+// WithRequireAllowlist : Reject clients without an allowlist. Enabled by default, disable it to allow them from any IP.
+func WithRequireAllowlist(required bool) IPAllowlistOption {
+	return func(cfg *ipAllowlistConfig) {
+		cfg.requireAllowlist = required
+	}
+}
+
+// ValidateClientIP : Check the caller IP against the allowlist of the authenticated integrator client.
+// The client resolved by ValidateAggregatorSignature is reused, otherwise it is loaded with resolver
+// from the JWTClaimsSignature ClientId. Rejections respond with common.ErrIPNotAllowed.
+// It panics when resolver is nil.
+func ValidateClientIP(resolver ClientResolver, opts ...IPAllowlistOption) fiber.Handler {
+	if resolver == nil {
+		panic("ip allowlist configuration: client resolver is required")
+	}
+
+	cfg := ipAllowlistConfig{requireAllowlist: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(c *fiber.Ctx) error {
+		client := contek.GetIntegratorClient(c.Context())
+		if client == nil {
+			claims := contek.GetClientContext(c.Context())
+			if claims == nil || claims.ClientId == "" {
+				slog.Warn("ValidateClientIP: client claims not found in Locals")
+				return common.Response().SetError(common.ErrUnauthorized).Send(c)
+			}
+
+			var err error
+			client, err = resolver.ResolveClient(c.Context(), claims.ClientId)
+			if errors.Is(err, ErrClientNotFound) || (err == nil && client == nil) {
+				slog.Warn("ValidateClientIP: integrator client not found", "client_id", claims.ClientId)
+				return common.Response().SetError(common.ErrUnauthorized).Send(c)
+			}
+			if err != nil {
+				slog.Warn("ValidateClientIP: integrator client lookup failed", "client_id", claims.ClientId, "error", err.Error())
+				return common.Response().SetError(common.ErrServerError).Send(c)
+			}
+		}
+
+		ip := cfg.clientIP(c)
+		if !ipAllowed(ip, client, cfg.requireAllowlist) {
+			slog.Warn("Client IP not allowed", "client_id", client.ID, "ip", ip.String())
+			return common.Response().SetError(common.ErrIPNotAllowed).Send(c)
+		}
+
+		return c.Next()
+	}
+}
+
+// clientIP returns the remote IP, or the first untrusted X-Forwarded-For hop when the remote IP is a trusted proxy
+func (cfg *ipAllowlistConfig) clientIP(c *fiber.Ctx) netip.Addr {
+	remote, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+	remote = remote.Unmap()
+	if !cfg.trusted(remote) {
+		return remote
+	}
+
+	hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		hop = hop.Unmap()
+		if !cfg.trusted(hop) {
+			return hop
+		}
+		remote = hop
+	}
+
+	return remote
+}
+
+func (cfg *ipAllowlistConfig) trusted(ip netip.Addr) bool {
+	for _, prefix := range cfg.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipAllowed reports whether ip matches one of the client allowed IPs or CIDR ranges
+func ipAllowed(ip netip.Addr, client *types.IntegratorClient, requireAllowlist bool) bool {
+	if len(client.AllowedIPs) == 0 {
+		return !requireAllowlist
+	}
+
+	for _, allowed := range client.AllowedIPs {
+		prefix, err := parsePrefix(allowed)
+		if err != nil {
+			slog.Warn("Invalid allowed IP for client", "client_id", client.ID, "allowed_ip", allowed)
+			continue
+		}
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parsePrefix parses a CIDR range or a single IP
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
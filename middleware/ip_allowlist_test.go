@@ -0,0 +1,119 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateClientIP(t *testing.T) {
+	issuer, err := middleware.NewIssuer(middleware.IssuerConfig{Secret: "secret"})
+	require.NoError(t, err)
+
+	resolver := middleware.NewMemoryClientResolver(
+		types.IntegratorClient{ID: "single", Status: types.Active, AllowedIPs: []string{"203.0.113.7"}},
+		types.IntegratorClient{ID: "range", Status: types.Active, AllowedIPs: []string{"198.51.100.0/24", "2001:db8::/32"}},
+		types.IntegratorClient{ID: "open", Status: types.Active},
+	)
+
+	// app.Test connections come from 0.0.0.0, trusting it lets the cases drive X-Forwarded-For
+	newApp := func(opts ...middleware.IPAllowlistOption) *fiber.App {
+		app := fiber.New()
+		app.Get("/wallet/balance",
+			middleware.NewAuthMiddlewareSignature("secret"),
+			middleware.ValidateClientIP(resolver, opts...),
+			func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) },
+		)
+		return app
+	}
+	trusted := middleware.WithTrustedProxies("0.0.0.0", "10.0.0.0/8")
+
+	tests := []struct {
+		name          string
+		opts          []middleware.IPAllowlistOption
+		clientID      string
+		forwardedFor  string
+		wantStatus    int
+		wantErrorCode int
+	}{
+		{name: "success - single IP", opts: []middleware.IPAllowlistOption{trusted}, clientID: "single", forwardedFor: "203.0.113.7", wantStatus: http.StatusOK},
+		{name: "success - CIDR range", opts: []middleware.IPAllowlistOption{trusted}, clientID: "range", forwardedFor: "198.51.100.42", wantStatus: http.StatusOK},
+		{name: "success - IPv6 range", opts: []middleware.IPAllowlistOption{trusted}, clientID: "range", forwardedFor: "2001:db8::1", wantStatus: http.StatusOK},
+		{name: "success - trusted hops are skipped", opts: []middleware.IPAllowlistOption{trusted}, clientID: "single", forwardedFor: "203.0.113.7, 10.1.2.3", wantStatus: http.StatusOK},
+		{
+			name:          "error - spoofed left-most entry",
+			opts:          []middleware.IPAllowlistOption{trusted},
+			clientID:      "single",
+			forwardedFor:  "203.0.113.7, 192.0.2.1",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrIPNotAllowed.Code,
+		},
+		{
+			name:          "error - outside range",
+			opts:          []middleware.IPAllowlistOption{trusted},
+			clientID:      "range",
+			forwardedFor:  "198.51.101.1",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrIPNotAllowed.Code,
+		},
+		{
+			name:          "error - forwarded header ignored without trusted proxies",
+			clientID:      "single",
+			forwardedFor:  "203.0.113.7",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrIPNotAllowed.Code,
+		},
+		{
+			name:       "success - client without allowlist when not required",
+			opts:       []middleware.IPAllowlistOption{middleware.WithRequireAllowlist(false)},
+			clientID:   "open",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:          "error - client without allowlist",
+			clientID:      "open",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrIPNotAllowed.Code,
+		},
+		{
+			name:          "error - unknown client",
+			clientID:      "unknown",
+			wantStatus:    http.StatusUnauthorized,
+			wantErrorCode: common.ErrUnauthorized.Code,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := issuer.IssueClientToken(types.JWTClaimsSignature{ClientId: tt.clientID})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/wallet/balance", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			if tt.forwardedFor != "" {
+				req.Header.Set(fiber.HeaderXForwardedFor, tt.forwardedFor)
+			}
+			resp, err := newApp(tt.opts...).Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantErrorCode != 0 {
+				assert.Equal(t, tt.wantErrorCode, responseCode(t, resp))
+			}
+		})
+	}
+}
+
+func TestValidateClientIP_InvalidConfig(t *testing.T) {
+	assert.Panics(t, func() {
+		middleware.ValidateClientIP(middleware.NewMemoryClientResolver(), middleware.WithTrustedProxies("not-an-ip"))
+	})
+	assert.Panics(t, func() { middleware.ValidateClientIP(nil) })
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultUserTokenTTL   = 15 * time.Minute
+	defaultClientTokenTTL = time.Hour
+)
+
+// IssuerConfig configures how tokens are signed and which registered claims are set by default
+type IssuerConfig struct {
+	// Secret signs tokens with HS256. Ignored when PrivateKey is set.
+	Secret string
+	// PrivateKey signs tokens with RS256 (*rsa.PrivateKey), ES256/ES384/ES512 (*ecdsa.PrivateKey) or EdDSA (ed25519.PrivateKey)
+	PrivateKey any
+	// KeyID is written to the "kid" header, matching the key published with WithPublicKeyID or a JWKS endpoint
+	KeyID string
+	// Issuer is the default "iss" claim
+	Issuer string
+	// Audience is the default "aud" claim
+	Audience []string
+	// UserTTL is the lifetime of JWTClaims tokens. Default: 15 minutes
+	UserTTL time.Duration
+	// ClientTTL is the lifetime of JWTClaimsSignature tokens. Default: 1 hour
+	ClientTTL time.Duration
+}
+
+// Issuer builds and signs JWTClaims and JWTClaimsSignature tokens
+type Issuer struct {
+	cfg    IssuerConfig
+	method jwt.SigningMethod
+	key    any
+	now    func() time.Time
+}
+
+// NewIssuer : Initialize new token issuer
+func NewIssuer(cfg IssuerConfig) (*Issuer, error) {
+	if cfg.UserTTL <= 0 {
+		cfg.UserTTL = defaultUserTokenTTL
+	}
+	if cfg.ClientTTL <= 0 {
+		cfg.ClientTTL = defaultClientTokenTTL
+	}
+
+	i := &Issuer{cfg: cfg, now: time.Now}
+	switch key := cfg.PrivateKey.(type) {
+	case nil:
+		if cfg.Secret == "" {
+			return nil, errors.New("either secret or private key is required")
+		}
+		i.method, i.key = jwt.SigningMethodHS256, []byte(cfg.Secret)
+	case *rsa.PrivateKey:
+		i.method, i.key = jwt.SigningMethodRS256, key
+	case *ecdsa.PrivateKey:
+		algs, err := algorithmsForKey(key)
+		if err != nil {
+			return nil, err
+		}
+		i.method, i.key = jwt.GetSigningMethod(algs[0]), key
+	case ed25519.PrivateKey:
+		i.method, i.key = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", cfg.PrivateKey)
+	}
+
+	return i, nil
+}
+
+// IssueUserToken : Sign a JWTClaims token, filling empty registered claims with defaults
+func (i *Issuer) IssueUserToken(claims types.JWTClaims) (string, error) {
+	i.applyDefaults(&claims.RegisteredClaims, claims.ID, i.cfg.UserTTL)
+	return i.sign(&claims)
+}
+
+// IssueClientToken : Sign a JWTClaimsSignature token, filling empty registered claims with defaults
+func (i *Issuer) IssueClientToken(claims types.JWTClaimsSignature) (string, error) {
+	i.applyDefaults(&claims.RegisteredClaims, claims.ClientId, i.cfg.ClientTTL)
+	return i.sign(&claims)
+}
+
+// applyDefaults sets jti, sub, iat, nbf, exp, iss and aud when they are not already set
+func (i *Issuer) applyDefaults(rc *jwt.RegisteredClaims, subject string, ttl time.Duration) {
+	now := i.now()
+
+	if rc.ID == "" {
+		rc.ID = newTokenID()
+	}
+	if rc.Subject == "" {
+		rc.Subject = subject
+	}
+	if rc.IssuedAt == nil {
+		rc.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if rc.NotBefore == nil {
+		rc.NotBefore = rc.IssuedAt
+	}
+	if rc.ExpiresAt == nil {
+		rc.ExpiresAt = jwt.NewNumericDate(rc.IssuedAt.Add(ttl))
+	}
+	if rc.Issuer == "" {
+		rc.Issuer = i.cfg.Issuer
+	}
+	if len(rc.Audience) == 0 && len(i.cfg.Audience) > 0 {
+		rc.Audience = i.cfg.Audience
+	}
+}
+
+func (i *Issuer) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(i.method, claims)
+	if i.cfg.KeyID != "" {
+		token.Header["kid"] = i.cfg.KeyID
+	}
+
+	return token.SignedString(i.key)
+}
+
+// newTokenID returns a random 128-bit hex identifier
+func newTokenID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package middleware_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuer_RoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		cfg    middleware.IssuerConfig
+		secret string
+		opts   []middleware.AuthOption
+	}{
+		{
+			name:   "HS256 secret",
+			cfg:    middleware.IssuerConfig{Secret: "secret"},
+			secret: "secret",
+		},
+		{
+			name: "RS256 private key with kid",
+			cfg:  middleware.IssuerConfig{PrivateKey: rsaKey, KeyID: "rsa-1"},
+			opts: []middleware.AuthOption{middleware.WithPublicKeyID("rsa-1", &rsaKey.PublicKey)},
+		},
+		{
+			name: "ES384 private key",
+			cfg:  middleware.IssuerConfig{PrivateKey: ecKey},
+			opts: []middleware.AuthOption{middleware.WithPublicKey(&ecKey.PublicKey)},
+		},
+		{
+			name: "EdDSA private key with issuer and audience",
+			cfg:  middleware.IssuerConfig{PrivateKey: edKey, Issuer: "auth", Audience: []string{"backoffice"}},
+			opts: []middleware.AuthOption{
+				middleware.WithPublicKey(edPub),
+				middleware.WithIssuer("auth"),
+				middleware.WithAudience("backoffice"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := middleware.NewIssuer(tt.cfg)
+			require.NoError(t, err)
+
+			userToken, err := issuer.IssueUserToken(types.JWTClaims{ID: "user-1", Type: types.RoleAdmin})
+			require.NoError(t, err)
+			resp := doRequest(t, protectedApp(middleware.NewAuthMiddleware(tt.secret, tt.opts...)), userToken)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			clientToken, err := issuer.IssueClientToken(types.JWTClaimsSignature{ClientId: "client-1"})
+			require.NoError(t, err)
+			resp = doRequest(t, protectedApp(middleware.NewAuthMiddlewareSignature(tt.secret, tt.opts...)), clientToken)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestIssuer_Defaults(t *testing.T) {
+	issuer, err := middleware.NewIssuer(middleware.IssuerConfig{
+		Secret:    "secret",
+		Issuer:    "auth",
+		Audience:  []string{"backoffice"},
+		UserTTL:   10 * time.Minute,
+		ClientTTL: 2 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	parse := func(raw string, claims jwt.Claims) {
+		_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) { return []byte("secret"), nil })
+		require.NoError(t, err)
+	}
+
+	raw, err := issuer.IssueUserToken(types.JWTClaims{ID: "user-1"})
+	require.NoError(t, err)
+	var user types.JWTClaims
+	parse(raw, &user)
+
+	assert.NotEmpty(t, user.RegisteredClaims.ID)
+	assert.Equal(t, "user-1", user.Subject)
+	assert.Equal(t, "auth", user.Issuer)
+	assert.Equal(t, jwt.ClaimStrings{"backoffice"}, user.Audience)
+	assert.Equal(t, user.IssuedAt.Time, user.NotBefore.Time)
+	assert.Equal(t, 10*time.Minute, user.ExpiresAt.Sub(user.IssuedAt.Time))
+
+	raw, err = issuer.IssueClientToken(types.JWTClaimsSignature{ClientId: "client-1"})
+	require.NoError(t, err)
+	var client types.JWTClaimsSignature
+	parse(raw, &client)
+
+	assert.Equal(t, "client-1", client.Subject)
+	assert.Equal(t, 2*time.Hour, client.ExpiresAt.Sub(client.IssuedAt.Time))
+
+	// Explicit registered claims are kept
+	expires := jwt.NewNumericDate(time.Now().Add(time.Minute).Truncate(time.Second))
+	raw, err = issuer.IssueUserToken(types.JWTClaims{
+		ID:               "user-2",
+		RegisteredClaims: jwt.RegisteredClaims{ID: "fixed-jti", Issuer: "other", ExpiresAt: expires},
+	})
+	require.NoError(t, err)
+	var explicit types.JWTClaims
+	parse(raw, &explicit)
+
+	assert.Equal(t, "fixed-jti", explicit.RegisteredClaims.ID)
+	assert.Equal(t, "other", explicit.Issuer)
+	assert.True(t, expires.Equal(explicit.ExpiresAt.Time))
+}
+
+func TestNewIssuer_InvalidConfig(t *testing.T) {
+	_, err := middleware.NewIssuer(middleware.IssuerConfig{})
+	assert.Error(t, err)
+
+	_, err = middleware.NewIssuer(middleware.IssuerConfig{PrivateKey: "not-a-key"})
+	assert.Error(t, err)
+}
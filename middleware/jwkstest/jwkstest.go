@@ -0,0 +1,119 @@
+// Package jwkstest provides a local JWKS endpoint for testing services that verify
+// tokens with middleware.WithJWKS.
+package jwkstest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Server is a JWKS endpoint backed by an in-memory key set.
+// Keys can be added and removed at any time to simulate key rotation.
+type Server struct {
+	*httptest.Server
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+}
+
+// JWK is a single JSON Web Key as published by the server
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// NewServer starts a JWKS server publishing the given keys, indexed by kid.
+// The caller must call Close when finished.
+func NewServer(keys map[string]crypto.PublicKey) *Server {
+	s := &Server{keys: make(map[string]crypto.PublicKey, len(keys))}
+	for kid, key := range keys {
+		s.keys[kid] = key
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// AddKey publishes a key under kid, replacing any key with the same kid
+func (s *Server) AddKey(kid string, key crypto.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+// RemoveKey stops publishing the key with the given kid
+func (s *Server) RemoveKey(kid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, kid)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.RLock()
+	set := struct {
+		Keys []JWK `json:"keys"`
+	}{Keys: make([]JWK, 0, len(s.keys))}
+	for kid, key := range s.keys {
+		jwk, err := NewJWK(kid, key)
+		if err != nil {
+			s.mu.RUnlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(set)
+}
+
+// NewJWK encodes an RSA, ECDSA or Ed25519 public key as a signing JWK
+func NewJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	enc := base64.RawURLEncoding
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA", Kid: kid, Alg: "RS256", Use: "sig",
+			N: enc.EncodeToString(k.N.Bytes()),
+			E: enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		point, err := k.Bytes()
+		if err != nil {
+			return JWK{}, err
+		}
+		size := (len(point) - 1) / 2
+		crv := k.Curve.Params().Name
+		alg := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[crv]
+
+		return JWK{
+			Kty: "EC", Kid: kid, Alg: alg, Use: "sig", Crv: crv,
+			X: enc.EncodeToString(point[1 : 1+size]),
+			Y: enc.EncodeToString(point[1+size:]),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP", Kid: kid, Alg: "EdDSA", Use: "sig", Crv: "Ed25519",
+			X: enc.EncodeToString(k),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("jwkstest: unsupported key type %T", key)
+	}
+}
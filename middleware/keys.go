@@ -0,0 +1,306 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errNoVerificationKey = errors.New("no verification key configured for token")
+	hmacAlgorithms       = []string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodHS384.Alg(), jwt.SigningMethodHS512.Alg()}
+)
+
+// AuthOption configures how the authentication middleware verifies tokens
+type AuthOption func(*authConfig)
+
+type authConfig struct {
+	secret        string
+	publicKeys    map[string]crypto.PublicKey
+	jwksURL       string
+	jwksRefresh   time.Duration
+	issuer        string
+	audience      []string
+	leeway        time.Duration
+	revocation    RevocationChecker
+	tokenLookup   string
+	staticSecrets []string
+
+	preflightPassThrough bool
+}
+
+// WithPublicKey : Verify RS256, ES256 or EdDSA tokens with the given public key.
+// The key is used for tokens without a matching "kid" header.
+func WithPublicKey(key crypto.PublicKey) AuthOption {
+	return WithPublicKeyID("", key)
+}
+
+// WithPublicKeyID : Verify tokens whose "kid" header equals kid with the given public key
+func WithPublicKeyID(kid string, key crypto.PublicKey) AuthOption {
+	return func(cfg *authConfig) {
+		if cfg.publicKeys == nil {
+			cfg.publicKeys = make(map[string]crypto.PublicKey)
+		}
+		cfg.publicKeys[kid] = key
+	}
+}
+
+// WithJWKS : Verify tokens with the keys published at a JWKS endpoint.
+// Keys are cached and refreshed every refreshInterval (default 1 hour) or when an unknown "kid" is seen.
+// Middlewares configured with the same URL share one key set, refreshed at the interval of the first one, see CloseJWKS.
+func WithJWKS(url string, refreshInterval time.Duration) AuthOption {
+	return func(cfg *authConfig) {
+		cfg.jwksURL = url
+		cfg.jwksRefresh = refreshInterval
+	}
+}
+
+// WithIssuer : Require the "iss" claim to equal issuer
+func WithIssuer(issuer string) AuthOption {
+	return func(cfg *authConfig) {
+		cfg.issuer = issuer
+	}
+}
+
+// WithAudience : Require the "aud" claim to contain at least one of the given audiences
+func WithAudience(audience ...string) AuthOption {
+	return func(cfg *authConfig) {
+		cfg.audience = audience
+	}
+}
+
+// WithLeeway : Allow a clock skew of leeway when validating "exp", "nbf" and "iat"
+func WithLeeway(leeway time.Duration) AuthOption {
+	return func(cfg *authConfig) {
+		cfg.leeway = leeway
+	}
+}
+
+// tokenVerifier verifies raw tokens against the configured keys and claim requirements
+type tokenVerifier struct {
+	secret     []byte
+	publicKeys map[string]crypto.PublicKey
+	// publicKeyAlgs holds the algorithms accepted for each public key, JWKS keys are checked by their "alg" parameter
+	publicKeyAlgs map[string][]string
+	jwks          *keyfunc.JWKS
+	parser        *jwt.Parser
+	revocation    RevocationChecker
+}
+
+func newAuthConfig(secret string, opts []AuthOption) authConfig {
+	cfg := authConfig{secret: secret, tokenLookup: defaultTokenLookup}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+func newTokenVerifier(cfg authConfig) (*tokenVerifier, error) {
+	if cfg.secret == "" && len(cfg.publicKeys) == 0 && cfg.jwksURL == "" {
+		return nil, errors.New("at least one of secret, public key or JWKS URL is required")
+	}
+
+	v := &tokenVerifier{publicKeys: cfg.publicKeys, publicKeyAlgs: make(map[string][]string), revocation: cfg.revocation}
+	var methods []string
+	if cfg.secret != "" {
+		v.secret = []byte(cfg.secret)
+		methods = append(methods, hmacAlgorithms...)
+	}
+	for kid, key := range cfg.publicKeys {
+		algs, err := algorithmsForKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("public key %q: %w", kid, err)
+		}
+		v.publicKeyAlgs[kid] = algs
+		methods = append(methods, algs...)
+	}
+
+	if cfg.jwksURL != "" {
+		jwks, err := sharedJWKS(cfg.jwksURL, cfg.jwksRefresh)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = jwks
+
+		// JWKS algorithms are only known at verification time, the secret and public keys
+		// keep their allow-list in keyFunc
+		methods = nil
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithLeeway(cfg.leeway)}
+	if len(methods) > 0 {
+		parserOpts = append(parserOpts, jwt.WithValidMethods(methods))
+	}
+	if cfg.issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(cfg.issuer))
+	}
+	if len(cfg.audience) > 0 {
+		parserOpts = append(parserOpts, jwt.WithAudience(cfg.audience...))
+	}
+	v.parser = jwt.NewParser(parserOpts...)
+
+	return v, nil
+}
+
+// verify parses the raw token into claims, validates its signature and registered claims,
+// then consults the revocation checker when configured
+func (v *tokenVerifier) verify(ctx context.Context, raw string, claims jwt.Claims) (*jwt.Token, error) {
+	token, err := v.parser.ParseWithClaims(raw, claims, v.keyFunc)
+	if err != nil || v.revocation == nil {
+		return token, err
+	}
+
+	var jti, subject string
+	var issuedAt time.Time
+	if principal, ok := claims.(types.Principal); ok {
+		subject = principal.PrincipalID()
+	}
+	if rc := registeredClaims(claims); rc != nil {
+		jti = rc.ID
+		if rc.IssuedAt != nil {
+			issuedAt = rc.IssuedAt.Time
+		}
+	}
+
+	revoked, err := v.revocation.IsRevoked(ctx, jti, subject, issuedAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errRevocationCheck, err)
+	}
+	if revoked {
+		return nil, errTokenRevoked
+	}
+
+	return token, nil
+}
+
+func (v *tokenVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if v.secret == nil || !slices.Contains(hmacAlgorithms, alg) {
+			return nil, errNoVerificationKey
+		}
+		return v.secret, nil
+	}
+
+	// Tokens without "kid" use the WithPublicKey key, they would only trigger an unknown kid JWKS refresh
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := v.publicKeys[kid]; ok {
+		return v.publicKey(kid, key, alg)
+	}
+	if v.jwks != nil {
+		return v.jwks.Keyfunc(token)
+	}
+
+	return nil, errNoVerificationKey
+}
+
+// publicKey returns key when alg is one of the algorithms accepted for it
+func (v *tokenVerifier) publicKey(kid string, key crypto.PublicKey, alg string) (interface{}, error) {
+	if !slices.Contains(v.publicKeyAlgs[kid], alg) {
+		return nil, fmt.Errorf("%w: algorithm %s not accepted for key %q", jwt.ErrTokenSignatureInvalid, alg, kid)
+	}
+
+	return key, nil
+}
+
+// jwksCache holds one key set per JWKS URL, each refreshed by a single background goroutine
+var jwksCache = struct {
+	mu   sync.Mutex
+	sets map[string]*keyfunc.JWKS
+}{sets: make(map[string]*keyfunc.JWKS)}
+
+// sharedJWKS returns the key set of url, fetching it on first use
+func sharedJWKS(url string, refresh time.Duration) (*keyfunc.JWKS, error) {
+	jwksCache.mu.Lock()
+	defer jwksCache.mu.Unlock()
+
+	if jwks, ok := jwksCache.sets[url]; ok {
+		return jwks, nil
+	}
+
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	jwks, err := keyfunc.Get(url, keyfunc.Options{
+		RefreshInterval:   refresh,
+		RefreshRateLimit:  time.Minute,
+		RefreshTimeout:    10 * time.Second,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			slog.Warn("JWKS refresh failed", "url", url, "error", err.Error())
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load JWKS from %s: %w", url, err)
+	}
+	jwksCache.sets[url] = jwks
+
+	return jwks, nil
+}
+
+// CloseJWKS : Stop the background refresh of every JWKS loaded with WithJWKS, e.g. on shutdown.
+// Middlewares created before keep verifying with the last fetched keys, new ones fetch the key sets again.
+func CloseJWKS() {
+	jwksCache.mu.Lock()
+	defer jwksCache.mu.Unlock()
+
+	for url, jwks := range jwksCache.sets {
+		jwks.EndBackground()
+		delete(jwksCache.sets, url)
+	}
+}
+
+// registeredClaims returns the registered claims embedded in the package claim types
+func registeredClaims(claims jwt.Claims) *jwt.RegisteredClaims {
+	switch c := claims.(type) {
+	case *types.JWTClaims:
+		return &c.RegisteredClaims
+	case *types.JWTClaimsSignature:
+		return &c.RegisteredClaims
+	default:
+		return nil
+	}
+}
+
+// algorithmsForKey returns the JWT algorithms that can be verified with the given public or private key
+func algorithmsForKey(key any) ([]string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}, nil
+	case *ecdsa.PublicKey:
+		return ecdsaAlgorithm(k.Curve)
+	case *ecdsa.PrivateKey:
+		return ecdsaAlgorithm(k.Curve)
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return []string{jwt.SigningMethodEdDSA.Alg()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func ecdsaAlgorithm(curve elliptic.Curve) ([]string, error) {
+	switch curve {
+	case elliptic.P256():
+		return []string{jwt.SigningMethodES256.Alg()}, nil
+	case elliptic.P384():
+		return []string{jwt.SigningMethodES384.Alg()}, nil
+	case elliptic.P521():
+		return []string{jwt.SigningMethodES512.Alg()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported elliptic curve %s", curve.Params().Name)
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"strings"
 
@@ -11,100 +12,33 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func getPermValue(perms types.PermissionsDTO, p types.Permission) int {
-	switch p {
-	case types.PermissionDashboard:
-		return perms.Dashboard
-	case types.PermissionReportPlayerActive:
-		return perms.ReportPlayerActive
-	case types.PermissionReportClients:
-		return perms.ReportClients
-	case types.PermissionReportSlot:
-		return perms.ReportSlot
-	case types.PermissionReportProfit:
-		return perms.ReportProfit
-	case types.PermissionReportClientShared:
-		return perms.ReportClientShared
-	case types.PermissionSuperAgent:
-		return perms.SuperAgent
-	case types.PermissionAgent:
-		return perms.Agent
-	case types.PermissionGameProviders:
-		return perms.GameProviders
-	case types.PermissionGames:
-		return perms.Games
-	case types.PermissionPlayerPendingTxn:
-		return perms.PlayerPendingTransaction
-	case types.PermissionSettings:
-		return perms.Settings
-	case types.PermissionRegenerateSecret:
-		return perms.PermissionRegenerateSecret
-	default:
-		return 0
-	}
+// ValidatePermission : Check the permission required by the route with DefaultPolicyEvaluator.
+// The action is set with SetRouteAction or derived from the method with types.MethodAction,
+// the "role" or "type" query selects one of the required permissions.
+func ValidatePermission(requiredPermissions ...types.Permission) func(*fiber.Ctx) error {
+	return ValidatePermissionWith(DefaultPolicyEvaluator, requiredPermissions...)
 }
 
-func parsePermissionFromQuery(q string) (types.Permission, bool) {
-	switch strings.ToLower(strings.TrimSpace(q)) {
-	case "dashboard":
-		return types.PermissionDashboard, true
-	case "report_player_active":
-		return types.PermissionReportPlayerActive, true
-	case "report_clients":
-		return types.PermissionReportClients, true
-	case "report_slot":
-		return types.PermissionReportSlot, true
-	case "report_profit":
-		return types.PermissionReportProfit, true
-	case "report_client_shared":
-		return types.PermissionReportClientShared, true
-	case "super_agent":
-		return types.PermissionSuperAgent, true
-	case "agent":
-		return types.PermissionAgent, true
-	case "game_providers":
-		return types.PermissionGameProviders, true
-	case "games":
-		return types.PermissionGames, true
-	case "player_pending_transaction":
-		return types.PermissionPlayerPendingTxn, true
-	case "settings":
-		return types.PermissionSettings, true
-	case "permission_regenerate_secret":
-		return types.PermissionRegenerateSecret, true
-	default:
-		return "", false
-	}
-}
+// ValidatePermissionWith : Same as ValidatePermission, decisions are made by evaluator
+func ValidatePermissionWith(evaluator PolicyEvaluator, requiredPermissions ...types.Permission) func(*fiber.Ctx) error {
+	mustEvaluator(evaluator, "ValidatePermissionWith")
 
-func ValidatePermission(requiredPermissions ...types.Permission) func(*fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		u := c.Locals("user")
-		if u == nil {
-			slog.Warn("ValidatePermission: JWT not found in Locals")
-			return common.Response().SetError(common.ErrUnauthorized).Send(c)
-		}
-
-		token, ok := u.(*jwt.Token)
-		if !ok || token == nil {
-			slog.Warn("ValidatePermission: JWT token invalid type")
-			return common.Response().SetError(common.ErrUnauthorized).Send(c)
-		}
-
-		claims, ok := token.Claims.(*types.JWTClaims)
-		if !ok || claims == nil {
-			slog.Warn("ValidatePermission: JWT claims invalid or nil")
+		claims, ok := userClaims(c, "ValidatePermission")
+		if !ok {
+			auditUnauthenticated(c)
 			return common.Response().SetError(common.ErrUnauthorized).Send(c)
 		}
 
-		if b, _ := json.Marshal(claims.Permissions); len(b) > 0 {
+		if b, _ := json.Marshal(claims.EffectivePermissions()); len(b) > 0 {
 			slog.Debug("ValidatePermission: permissions snapshot", "permissions", string(b))
 		}
 
 		method := c.Method()
-		action, ok := types.MethodAction[method]
+		action, ok := routeAction(c)
 		if !ok {
 			slog.Warn("ValidatePermission: method not mapped to action", "method", method)
+			audit(c, claims, AuditEvent{Outcome: AuditDenied, Reason: "method not mapped to action"})
 			return common.Response().SetError(common.ErrForbidden).Send(c)
 		}
 
@@ -115,7 +49,9 @@ func ValidatePermission(requiredPermissions ...types.Permission) func(*fiber.Ctx
 			query = queryType
 		}
 
-		if permFromQuery, has := parsePermissionFromQuery(query); has {
+		req := AuthzRequest{Principal: claims, Action: action, Attributes: authzAttributes(c)}
+
+		if permFromQuery, has := types.ParsePermission(query); has {
 			if len(requiredPermissions) > 0 {
 				foundInRequired := false
 				for _, rp := range requiredPermissions {
@@ -127,47 +63,100 @@ func ValidatePermission(requiredPermissions ...types.Permission) func(*fiber.Ctx
 				if !foundInRequired {
 					slog.Info("Permission denied - queried permission not in required list",
 						"userID", claims.ID, "query", query, "method", method)
+					audit(c, claims, AuditEvent{
+						Permission: string(permFromQuery), Actions: action.Names(),
+						Outcome: AuditDenied, Reason: "queried permission not in required list",
+					})
 					return common.Response().SetError(common.ErrForbidden).Send(c)
 				}
 			}
 
-			permVal := getPermValue(claims.Permissions, permFromQuery)
-			if (permVal & int(action)) != 0 {
+			req.Resource = permFromQuery
+			decision, err := evaluateStepUp(c.Context(), evaluator, claims, req)
+			if err != nil {
+				slog.Warn("Permission evaluation failed", "userID", claims.ID, "permission", string(permFromQuery), "error", err.Error())
+				audit(c, claims, AuditEvent{Permission: string(permFromQuery), Actions: action.Names(), Outcome: AuditError, Reason: err.Error()})
+				return common.Response().SetError(common.ErrServerError).Send(c)
+			}
+			if decision.Allowed {
 				slog.Info("Permission granted",
 					"userID", claims.ID, "permission", string(permFromQuery),
-					"action", action, "method", method, "query", query)
+					"action", action, "method", method, "query", query, "reason", decision.Reason)
+				audit(c, claims, AuditEvent{Permission: string(permFromQuery), Actions: action.Names(), Outcome: AuditGranted, Reason: decision.Reason})
 				return c.Next()
 			}
 
 			slog.Info("Permission denied",
 				"userID", claims.ID, "permission", string(permFromQuery),
-				"action", action, "method", method, "query", query, "value", permVal)
-			return common.Response().SetError(common.ErrForbidden).Send(c)
+				"action", action, "method", method, "query", query, "reason", decision.Reason)
+			audit(c, claims, AuditEvent{Permission: string(permFromQuery), Actions: action.Names(), Outcome: AuditDenied, Reason: decision.Reason})
+			return common.Response().SetError(denialError(decision)).Send(c)
 		}
 
 		if len(requiredPermissions) == 0 {
+			audit(c, claims, AuditEvent{Actions: action.Names(), Outcome: AuditGranted, Reason: "no permission required"})
 			return c.Next()
 		}
 
+		required := make([]string, 0, len(requiredPermissions))
+		reasons := make([]string, 0, len(requiredPermissions))
+		var denial Decision
 		for _, p := range requiredPermissions {
-			permVal := getPermValue(claims.Permissions, p)
-			if (permVal & int(action)) != 0 {
+			req.Resource = p
+			decision, err := evaluateStepUp(c.Context(), evaluator, claims, req)
+			if err != nil {
+				slog.Warn("Permission evaluation failed", "userID", claims.ID, "permission", string(p), "error", err.Error())
+				audit(c, claims, AuditEvent{Permission: string(p), Actions: action.Names(), Outcome: AuditError, Reason: err.Error()})
+				return common.Response().SetError(common.ErrServerError).Send(c)
+			}
+			if decision.Allowed {
 				slog.Info("Permission granted",
 					"userID", claims.ID, "permission", string(p),
-					"action", action, "method", method)
+					"action", action, "method", method, "reason", decision.Reason)
+				audit(c, claims, AuditEvent{Permission: string(p), Actions: action.Names(), Outcome: AuditGranted, Reason: decision.Reason})
 				return c.Next()
 			}
 			slog.Debug("Permission not sufficient",
 				"userID", claims.ID, "permission", string(p),
-				"have", permVal, "needAction", action, "method", method)
+				"needAction", action, "method", method, "reason", decision.Reason)
+			required = append(required, string(p))
+			reasons = append(reasons, decision.Reason)
+			denial.stepUp = denial.stepUp || decision.stepUp
 		}
 
 		slog.Info("Permission denied - no valid permissions found",
 			"userID", claims.ID, "method", method, "required", requiredPermissions)
-		return common.Response().SetError(common.ErrForbidden).Send(c)
+		audit(c, claims, AuditEvent{
+			Permission: strings.Join(required, ","), Actions: action.Names(),
+			Outcome: AuditDenied, Reason: strings.Join(reasons, "; "),
+		})
+		return common.Response().SetError(denialError(denial)).Send(c)
 	}
 }
 
+// userClaims returns the JWTClaims set by NewAuthMiddleware, logging why they are missing with the caller name
+func userClaims(c *fiber.Ctx, caller string) (*types.JWTClaims, bool) {
+	u := c.Locals("user")
+	if u == nil {
+		slog.Warn(caller + ": JWT not found in Locals")
+		return nil, false
+	}
+
+	token, ok := u.(*jwt.Token)
+	if !ok || token == nil {
+		slog.Warn(caller + ": JWT token invalid type")
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(*types.JWTClaims)
+	if !ok || claims == nil {
+		slog.Warn(caller + ": JWT claims invalid or nil")
+		return nil, false
+	}
+
+	return claims, true
+}
+
 func ValidatePermissionUserClient() func(*fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user := c.Locals("user")
@@ -191,11 +180,46 @@ func ValidatePermissionUserClient() func(*fiber.Ctx) error {
 		return c.Next()
 	}
 }
-func ValidateAggregatorSignature() fiber.Handler {
+
+// AggregatorOption configures ValidateAggregatorSignature
+type AggregatorOption func(*aggregatorConfig)
+
+type aggregatorConfig struct {
+	legacySignatures bool
+	replayCache      ReplayCache
+	validator        *SignatureValidator
+	clientResolver   ClientResolver
+}
+
+// WithSignatureValidator : Validate signatures with v instead of the default 5 minute window
+func WithSignatureValidator(v *SignatureValidator) AggregatorOption {
+	return func(cfg *aggregatorConfig) {
+		cfg.validator = v
+	}
+}
+
+// WithLegacySignatures : Accept v1 signatures alongside v2 signatures. Enabled by default during the migration window.
+func WithLegacySignatures(enabled bool) AggregatorOption {
+	return func(cfg *aggregatorConfig) {
+		cfg.legacySignatures = enabled
+	}
+}
+
+// ValidateAggregatorSignature : Validate the X-Aggregator-Signature header of an integrator request.
+// v2 signatures are keyed by the client secret and cover the method, path, query and body.
+// The secret is only loaded server-side with WithClientResolver, never from the token which travels with the request,
+// so v2 signatures are rejected with ErrSignatureMissingSecret without a resolver.
+// v1 signatures are accepted while WithLegacySignatures is enabled.
+func ValidateAggregatorSignature(opts ...AggregatorOption) fiber.Handler {
+	cfg := aggregatorConfig{legacySignatures: true, validator: defaultSignatureValidator}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	return func(c *fiber.Ctx) error {
-		signature := c.Get("X-Aggregator-Signature")
+		signature := c.Get(cfg.validator.Header())
 		if signature == "" {
-			slog.Warn("X-Aggregator-Signature header not found")
+			slog.Warn("Signature header not found", "header", cfg.validator.Header())
 			return common.Response().SetError(common.ErrMissingAggregatorSignature).Send(c)
 		}
 
@@ -224,33 +248,69 @@ func ValidateAggregatorSignature() fiber.Handler {
 		}
 		slog.Info("Validating signature for client_id", "client_id", clientID)
 
-		/* TODO: access integrator agent from valkey
-		agentRepository := repository.NewIntegratorDao(database.DB)
-		agent, err := agentRepository.GetById(c.Context(), clientID)
-		if err != nil {
-			slog.Warn("Agent not found", "client_id", clientID, "error", err.Error())
-			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		var secret string
+		if cfg.clientResolver != nil {
+			client, err := cfg.clientResolver.ResolveClient(c.Context(), clientID)
+			if errors.Is(err, ErrClientNotFound) {
+				slog.Warn("Integrator client not found", "client_id", clientID)
+				return common.Response().SetError(common.ErrUnauthorized).Send(c)
+			}
+			if err != nil {
+				slog.Warn("Integrator client lookup failed", "client_id", clientID, "error", err.Error())
+				return common.Response().SetError(common.ErrServerError).Send(c)
+			}
+
+			if client.Status != types.Active {
+				slog.Warn("Integrator client is not active", "client_id", clientID, "status", client.Status)
+				return common.Response().SetError(common.ErrUnauthorized).Send(c)
+			}
+
+			secret = client.Secret
+			c.Locals("client", client)
 		}
 
-		if agent.User.Status != "active" {
-			slog.Warn("Agent is not active", "client_id", clientID, "status", agent.User.Status)
-			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		var err error
+		req := SignatureRequest{
+			Method: c.Method(),
+			Path:   c.Path(),
+			Query:  string(c.Request().URI().QueryString()),
+			Body:   c.Body(),
+		}
+		v2 := IsSignatureV2(signature)
+		switch {
+		case v2:
+			err = cfg.validator.ValidateV2(signature, req, secret)
+		case cfg.legacySignatures:
+			slog.Debug("Legacy v1 signature used", "client_id", clientID)
+			err = cfg.validator.Validate(signature)
+		default:
+			slog.Warn("Legacy v1 signature rejected", "client_id", clientID)
+			return common.Response().SetError(common.ErrInvalidSignature).Send(c)
 		}
-		*/
 
-		if err := ValidateSignature(signature); err != nil {
+		if err != nil {
 			slog.Warn("Signature validation failed", "client_id", clientID, "error", err.Error())
 
-			if strings.Contains(err.Error(), "signature expired") {
+			if errors.Is(err, ErrSignatureExpired) {
 				return common.Response().SetError(common.ErrSessionExpired).Send(c)
 			}
 
 			return common.Response().SetError(common.ErrInvalidSignature).Send(c)
 		}
 
-		c.Locals("user", claims)
+		if cfg.replayCache != nil {
+			seen, err := cfg.replayCache.Seen(c.Context(), replayKey(clientID, signature, req), cfg.validator.ReplayWindow())
+			if err != nil {
+				slog.Warn("Signature replay check failed", "client_id", clientID, "error", err.Error())
+				return common.Response().SetError(common.ErrServerError).Send(c)
+			}
+			if seen {
+				slog.Warn("Signature replay rejected", "client_id", clientID)
+				return common.Response().SetError(common.ErrSignatureReplayed).Send(c)
+			}
+		}
 
-		//slog.Info("Signature validation successful", "client_id", agent.ID)
+		slog.Info("Signature validation successful", "client_id", clientID)
 		return c.Next()
 	}
 }
@@ -0,0 +1,102 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePermission(t *testing.T) {
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{
+			types.PermissionGames:        types.ActionRead | types.ActionWrite,
+			types.PermissionReportProfit: types.ActionRead,
+		}
+	}))
+
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	app.Get("/games", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionGames), ok)
+	app.Delete("/games", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionGames), ok)
+	app.Get("/reports", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionReportSlot, types.PermissionReportProfit), ok)
+	app.Get("/members", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionSuperAgent, types.PermissionGames), ok)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "success - read granted", method: http.MethodGet, target: "/games", wantStatus: http.StatusOK},
+		{name: "error - delete not granted", method: http.MethodDelete, target: "/games", wantStatus: http.StatusForbidden},
+		{name: "success - any required permission", method: http.MethodGet, target: "/reports", wantStatus: http.StatusOK},
+		{name: "success - query selects a granted permission", method: http.MethodGet, target: "/members?type=GAMES", wantStatus: http.StatusOK},
+		{name: "error - query selects a permission not granted", method: http.MethodGet, target: "/members?role=super_agent", wantStatus: http.StatusForbidden},
+		{name: "error - query selects a permission not required", method: http.MethodGet, target: "/reports?role=games", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RequestURI = tt.target
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantStatus == http.StatusForbidden {
+				assert.Equal(t, common.ErrForbidden.Code, responseCode(t, resp))
+			}
+		})
+	}
+}
+
+func TestValidatePermission_RoleDefaults(t *testing.T) {
+	const reporter types.Role = "test_reporter"
+	types.RegisterRole(types.RoleDefinition{
+		Role:        reporter,
+		Permissions: types.PermissionsDTO{types.PermissionReportProfit: types.ActionRead},
+	})
+
+	app := fiber.New()
+	app.Get("/reports", middleware.NewAuthMiddleware("secret"), middleware.ValidatePermission(types.PermissionReportProfit),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		role       types.Role
+		wantStatus int
+	}{
+		{name: "success - granted by role without token permissions", role: reporter, wantStatus: http.StatusOK},
+		{name: "error - role without defaults", role: types.RoleUser, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+				c.Type = tt.role
+			}))
+			resp := doRequestPath(t, app, "/reports", token)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func doRequestPath(t *testing.T, app *fiber.App, target, token string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	return resp
+}
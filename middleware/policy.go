@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RoutePolicy declares the permission and action required by a route
+type RoutePolicy struct {
+	// Method is the HTTP method, "" or "*" matches any method
+	Method string
+	// Path is the route pattern relative to the group, ":name" matches one segment and a trailing "*" the rest
+	Path string
+	// Query restricts the policy to requests whose query values match, case-insensitively, e.g. {"type": "games"}
+	Query map[string]string
+	// Permission is the permission required by the route
+	Permission types.Permission
+	// Action is the action required on Permission, derived from the method with types.MethodAction when 0
+	Action types.PermissionAction
+	// Public skips the permission check, e.g. for the profile of the caller
+	Public bool
+}
+
+type compiledPolicy struct {
+	RoutePolicy
+	segments []string
+}
+
+// PolicyTable is an ordered list of route policies. The first policy matching the request applies,
+// so policies conditioned on query values must be declared before the unconditioned policy of the same route.
+type PolicyTable struct {
+	mu       sync.RWMutex
+	policies []compiledPolicy
+}
+
+// NewPolicyTable : Initialize new policy table holding policies declared at the root
+func NewPolicyTable(policies ...RoutePolicy) *PolicyTable {
+	return new(PolicyTable).Group("", policies...)
+}
+
+// Group : Declare the policies of a route group, their paths are relative to prefix.
+// It panics when a policy has no path or requires an unregistered permission or an undeclared action.
+func (t *PolicyTable) Group(prefix string, policies ...RoutePolicy) *PolicyTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, policy := range policies {
+		compiled, err := compilePolicy(prefix, policy)
+		if err != nil {
+			panic("policy table configuration: " + err.Error())
+		}
+		t.policies = append(t.policies, compiled)
+	}
+
+	return t
+}
+
+func compilePolicy(prefix string, policy RoutePolicy) (compiledPolicy, error) {
+	if policy.Path == "" && prefix == "" {
+		return compiledPolicy{}, fmt.Errorf("policy %s without path", policy.Method)
+	}
+	policy.Method = strings.ToUpper(policy.Method)
+	if policy.Method == "*" {
+		policy.Method = ""
+	}
+	policy.Path = "/" + strings.Trim(strings.TrimRight(prefix, "/")+"/"+strings.TrimLeft(policy.Path, "/"), "/")
+
+	segments := pathSegments(policy.Path)
+	for i, segment := range segments {
+		if segment == "*" && i != len(segments)-1 {
+			return compiledPolicy{}, fmt.Errorf("policy %s %s: wildcard must be the last segment", policy.Method, policy.Path)
+		}
+	}
+
+	if !policy.Public {
+		def, ok := types.LookupPermission(string(policy.Permission))
+		if !ok {
+			return compiledPolicy{}, fmt.Errorf("policy %s %s: unknown permission %q", policy.Method, policy.Path, policy.Permission)
+		}
+		policy.Permission = def.Key
+		if policy.Action != 0 && policy.Action&^def.Actions != 0 {
+			return compiledPolicy{}, fmt.Errorf("policy %s %s: action %v not declared by permission %s", policy.Method, policy.Path, policy.Action.Names(), def.Key)
+		}
+	}
+
+	return compiledPolicy{RoutePolicy: policy, segments: segments}, nil
+}
+
+// Match : Get the policy of a request, query returns the value of a query parameter
+func (t *PolicyTable) Match(method, path string, query func(key string) string) (RoutePolicy, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	segments := pathSegments(path)
+	for _, policy := range t.policies {
+		if policy.Method != "" && policy.Method != method {
+			continue
+		}
+		if !matchSegments(policy.segments, segments) {
+			continue
+		}
+		if !matchQuery(policy.Query, query) {
+			continue
+		}
+
+		return policy.RoutePolicy, true
+	}
+
+	return RoutePolicy{}, false
+}
+
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for i, segment := range pattern {
+		if segment == "*" {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if !strings.HasPrefix(segment, ":") && segment != segments[i] {
+			return false
+		}
+	}
+
+	return len(pattern) == len(segments)
+}
+
+func matchQuery(conditions map[string]string, query func(key string) string) bool {
+	for key, value := range conditions {
+		if !strings.EqualFold(strings.TrimSpace(query(key)), value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requiredAction returns the action required by the policy for method, 0 when the method maps to no action
+func (p RoutePolicy) requiredAction(method string) types.PermissionAction {
+	if p.Action != 0 {
+		return p.Action
+	}
+
+	return types.MethodAction[method]
+}
+
+// policyEntry is the JSON form of a RoutePolicy
+type policyEntry struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Query      map[string]string `json:"query,omitempty"`
+	Permission types.Permission  `json:"permission,omitempty"`
+	Actions    []string          `json:"actions,omitempty"`
+	Public     bool              `json:"public,omitempty"`
+}
+
+// JSON : Dump the effective policy table for security review, in match order.
+// Policies matching any method without an explicit action are listed once per method of types.MethodAction.
+func (t *PolicyTable) JSON() ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entries := make([]policyEntry, 0, len(t.policies))
+	for _, policy := range t.policies {
+		methods := []string{policy.Method}
+		if policy.Method == "" && policy.Action == 0 && !policy.Public {
+			methods = mappedMethods()
+		}
+
+		for _, method := range methods {
+			entry := policyEntry{Method: method, Path: policy.Path, Query: policy.Query, Public: policy.Public}
+			if entry.Method == "" {
+				entry.Method = "*"
+			}
+			if !policy.Public {
+				entry.Permission = policy.Permission
+				entry.Actions = policy.requiredAction(method).Names()
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return json.MarshalIndent(entries, "", "  ")
+}
+
+// mappedMethods returns the methods of types.MethodAction in a stable order
+func mappedMethods() []string {
+	methods := make([]string, 0, len(types.MethodAction))
+	for method := range types.MethodAction {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
+
+// EnforcePolicy : Check every request against the policy table with DefaultPolicyEvaluator,
+// requests without a matching policy are denied.
+// Mount it after the authentication middleware, e.g. on a route group with app.Group("/api", auth, EnforcePolicy(table)).
+func EnforcePolicy(table *PolicyTable) fiber.Handler {
+	return EnforcePolicyWith(DefaultPolicyEvaluator, table)
+}
+
+// EnforcePolicyWith : Same as EnforcePolicy, decisions are made by evaluator
+func EnforcePolicyWith(evaluator PolicyEvaluator, table *PolicyTable) fiber.Handler {
+	mustEvaluator(evaluator, "EnforcePolicyWith")
+
+	return func(c *fiber.Ctx) error {
+		method := c.Method()
+		policy, ok := table.Match(method, c.Path(), func(key string) string { return c.Query(key) })
+		if !ok {
+			slog.Warn("Policy denied - no policy for route", "method", method, "path", c.Path())
+			audit(c, contek.GetPrincipal(c.Context()), AuditEvent{Outcome: AuditDenied, Reason: "no policy for route"})
+			return common.Response().SetError(common.ErrForbidden).Send(c)
+		}
+		if policy.Public {
+			audit(c, contek.GetPrincipal(c.Context()), AuditEvent{Route: policy.Path, Outcome: AuditGranted, Reason: "public policy"})
+			return c.Next()
+		}
+
+		claims := contek.GetUserContext(c.Context())
+		if claims == nil {
+			slog.Warn("EnforcePolicy: JWT claims not found in Locals")
+			auditUnauthenticated(c)
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		}
+
+		action := policy.requiredAction(method)
+		if action == 0 {
+			slog.Warn("Policy denied - method not mapped to action", "method", method, "path", c.Path())
+			audit(c, claims, AuditEvent{Permission: string(policy.Permission), Route: policy.Path, Outcome: AuditDenied, Reason: "method not mapped to action"})
+			return common.Response().SetError(common.ErrForbidden).Send(c)
+		}
+
+		decision, err := evaluateStepUp(c.Context(), evaluator, claims, AuthzRequest{
+			Principal:  claims,
+			Action:     action,
+			Resource:   policy.Permission,
+			Attributes: authzAttributes(c),
+		})
+		if err != nil {
+			slog.Warn("Policy evaluation failed", "userID", claims.ID, "path", c.Path(), "error", err.Error())
+			audit(c, claims, AuditEvent{Permission: string(policy.Permission), Actions: action.Names(), Route: policy.Path, Outcome: AuditError, Reason: err.Error()})
+			return common.Response().SetError(common.ErrServerError).Send(c)
+		}
+		if !decision.Allowed {
+			slog.Info("Policy denied",
+				"userID", claims.ID, "permission", string(policy.Permission),
+				"action", action, "method", method, "path", c.Path(), "policy", policy.Path, "reason", decision.Reason)
+			audit(c, claims, AuditEvent{Permission: string(policy.Permission), Actions: action.Names(), Route: policy.Path, Outcome: AuditDenied, Reason: decision.Reason})
+			return common.Response().SetError(denialError(decision)).Send(c)
+		}
+
+		slog.Debug("Policy granted",
+			"userID", claims.ID, "permission", string(policy.Permission),
+			"action", action, "method", method, "path", c.Path(), "reason", decision.Reason)
+		audit(c, claims, AuditEvent{Permission: string(policy.Permission), Actions: action.Names(), Route: policy.Path, Outcome: AuditGranted, Reason: decision.Reason})
+		return c.Next()
+	}
+}
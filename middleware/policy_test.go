@@ -0,0 +1,117 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicyTable() *middleware.PolicyTable {
+	return middleware.NewPolicyTable(
+		middleware.RoutePolicy{Method: http.MethodGet, Path: "/me", Public: true},
+	).Group("/api/v1/games",
+		middleware.RoutePolicy{Method: http.MethodGet, Path: "/", Permission: types.PermissionGames},
+		middleware.RoutePolicy{Method: "*", Path: "/:id", Permission: types.PermissionGames},
+		middleware.RoutePolicy{Method: http.MethodPost, Path: "/:id/regenerate", Permission: types.PermissionRegenerateSecret, Action: types.ActionWrite},
+	).Group("/api/v1/reports",
+		middleware.RoutePolicy{Method: http.MethodGet, Query: map[string]string{"type": "profit"}, Permission: types.PermissionReportProfit},
+		middleware.RoutePolicy{Method: http.MethodGet, Query: map[string]string{"type": "slot"}, Permission: types.PermissionReportSlot},
+		middleware.RoutePolicy{Method: http.MethodPost, Path: "/export/*", Permission: types.PermissionReportProfit, Action: types.ActionRead},
+	)
+}
+
+func TestEnforcePolicy(t *testing.T) {
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Permissions = types.PermissionsDTO{
+			types.PermissionGames:        types.ActionRead | types.ActionWrite,
+			types.PermissionReportProfit: types.ActionRead,
+		}
+	}))
+
+	app := fiber.New()
+	app.Use(middleware.NewAuthMiddleware("secret"), middleware.EnforcePolicy(testPolicyTable()))
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "success - public route", method: http.MethodGet, target: "/me", wantStatus: http.StatusOK},
+		{name: "success - group root", method: http.MethodGet, target: "/api/v1/games", wantStatus: http.StatusOK},
+		{name: "success - path param with action from method", method: http.MethodPut, target: "/api/v1/games/42", wantStatus: http.StatusOK},
+		{name: "error - action from method not granted", method: http.MethodDelete, target: "/api/v1/games/42", wantStatus: http.StatusForbidden},
+		{name: "error - explicit action on another permission", method: http.MethodPost, target: "/api/v1/games/42/regenerate", wantStatus: http.StatusForbidden},
+		{name: "success - query condition", method: http.MethodGet, target: "/api/v1/reports?type=PROFIT", wantStatus: http.StatusOK},
+		{name: "error - query condition selecting a permission not granted", method: http.MethodGet, target: "/api/v1/reports?type=slot", wantStatus: http.StatusForbidden},
+		{name: "error - query without policy", method: http.MethodGet, target: "/api/v1/reports", wantStatus: http.StatusForbidden},
+		{name: "success - wildcard with explicit action", method: http.MethodPost, target: "/api/v1/reports/export/2024/05", wantStatus: http.StatusOK},
+		{name: "error - route without policy", method: http.MethodGet, target: "/api/v1/settings", wantStatus: http.StatusForbidden},
+		{name: "error - method without policy", method: http.MethodPost, target: "/api/v1/games", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RequestURI = tt.target
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantStatus == http.StatusForbidden {
+				assert.Equal(t, common.ErrForbidden.Code, responseCode(t, resp))
+			}
+		})
+	}
+}
+
+func TestPolicyTable_JSON(t *testing.T) {
+	table := middleware.NewPolicyTable().Group("/api/v1/games",
+		middleware.RoutePolicy{Method: http.MethodGet, Path: "/", Permission: types.PermissionGames},
+		middleware.RoutePolicy{Path: "/:id", Permission: types.PermissionGames},
+		middleware.RoutePolicy{Method: http.MethodGet, Path: "/health", Public: true},
+		middleware.RoutePolicy{Method: http.MethodGet, Path: "/report", Query: map[string]string{"type": "profit"}, Permission: types.PermissionReportProfit},
+	)
+
+	b, err := table.JSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"method":"GET","path":"/api/v1/games","permission":"games","actions":["read"]},
+		{"method":"DELETE","path":"/api/v1/games/:id","permission":"games","actions":["delete"]},
+		{"method":"GET","path":"/api/v1/games/:id","permission":"games","actions":["read"]},
+		{"method":"HEAD","path":"/api/v1/games/:id","permission":"games","actions":["read"]},
+		{"method":"OPTIONS","path":"/api/v1/games/:id","permission":"games","actions":["read"]},
+		{"method":"PATCH","path":"/api/v1/games/:id","permission":"games","actions":["write"]},
+		{"method":"POST","path":"/api/v1/games/:id","permission":"games","actions":["write"]},
+		{"method":"PUT","path":"/api/v1/games/:id","permission":"games","actions":["write"]},
+		{"method":"GET","path":"/api/v1/games/health","public":true},
+		{"method":"GET","path":"/api/v1/games/report","query":{"type":"profit"},"permission":"report_profit","actions":["read"]}
+	]`, string(b))
+}
+
+func TestPolicyTable_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy middleware.RoutePolicy
+	}{
+		{name: "error - unknown permission", policy: middleware.RoutePolicy{Path: "/x", Permission: "unknown"}},
+		{name: "error - missing path", policy: middleware.RoutePolicy{Permission: types.PermissionGames}},
+		{name: "error - wildcard not last", policy: middleware.RoutePolicy{Path: "/x/*/y", Permission: types.PermissionGames}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Panics(t, func() { middleware.NewPolicyTable(tt.policy) })
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+)
+
+const defaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+// ErrRefreshTokenNotFound is returned by a RefreshStore when no token matches the given hash
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// RefreshToken is a stored refresh token. Only the SHA-256 hash of the opaque value is stored.
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	Subject   string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// RefreshStore persists refresh tokens grouped by token family
+type RefreshStore interface {
+	// Save stores a new refresh token
+	Save(ctx context.Context, token RefreshToken) error
+	// Consume atomically marks the token as used and returns it as it was before the call.
+	// It returns ErrRefreshTokenNotFound when no token matches hash.
+	Consume(ctx context.Context, hash string) (RefreshToken, error)
+	// RevokeFamily deletes every token of the family
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
+// RefreshTokenManager issues opaque refresh tokens and rotates them on every use.
+// Presenting a token that was already rotated revokes its whole family.
+type RefreshTokenManager struct {
+	store RefreshStore
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewRefreshTokenManager : Initialize new refresh token manager, ttl defaults to 7 days
+func NewRefreshTokenManager(store RefreshStore, ttl time.Duration) *RefreshTokenManager {
+	if ttl <= 0 {
+		ttl = defaultRefreshTokenTTL
+	}
+
+	return &RefreshTokenManager{store: store, ttl: ttl, now: time.Now}
+}
+
+// Issue : Start a new token family for subject and return its first refresh token
+func (m *RefreshTokenManager) Issue(ctx context.Context, subject string) (string, error) {
+	return m.issue(ctx, newTokenID(), subject)
+}
+
+// Rotate : Exchange a refresh token for a new one of the same family and return the subject it was issued to.
+// Unknown and replayed tokens return common.ErrInvalidToken, expired tokens return common.ErrSessionExpired.
+func (m *RefreshTokenManager) Rotate(ctx context.Context, token string) (newToken string, subject string, err error) {
+	prev, err := m.store.Consume(ctx, hashRefreshToken(token))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return "", "", common.ErrInvalidToken
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("consume refresh token: %w", err)
+	}
+
+	if prev.Used {
+		slog.Warn("Refresh token reuse detected, revoking token family", "family_id", prev.FamilyID, "subject", prev.Subject)
+		if err := m.store.RevokeFamily(ctx, prev.FamilyID); err != nil {
+			return "", "", fmt.Errorf("revoke refresh token family: %w", err)
+		}
+		return "", "", common.ErrInvalidToken
+	}
+
+	if !m.now().Before(prev.ExpiresAt) {
+		if err := m.store.RevokeFamily(ctx, prev.FamilyID); err != nil {
+			return "", "", fmt.Errorf("revoke refresh token family: %w", err)
+		}
+		return "", "", common.ErrSessionExpired
+	}
+
+	newToken, err = m.issue(ctx, prev.FamilyID, prev.Subject)
+	if err != nil {
+		return "", "", err
+	}
+
+	return newToken, prev.Subject, nil
+}
+
+// Revoke : Revoke the family of the given refresh token, e.g. on logout
+func (m *RefreshTokenManager) Revoke(ctx context.Context, token string) error {
+	prev, err := m.store.Consume(ctx, hashRefreshToken(token))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return common.ErrInvalidToken
+	}
+	if err != nil {
+		return fmt.Errorf("consume refresh token: %w", err)
+	}
+
+	return m.store.RevokeFamily(ctx, prev.FamilyID)
+}
+
+func (m *RefreshTokenManager) issue(ctx context.Context, familyID, subject string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := m.store.Save(ctx, RefreshToken{
+		Hash:      hashRefreshToken(token),
+		FamilyID:  familyID,
+		Subject:   subject,
+		ExpiresAt: m.now().Add(m.ttl),
+	})
+	if err != nil {
+		return "", fmt.Errorf("save refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryRefreshStore is an in-memory RefreshStore, suitable for tests and single instance deployments
+type MemoryRefreshStore struct {
+	mu       sync.Mutex
+	tokens   map[string]RefreshToken
+	families map[string][]string
+}
+
+// NewMemoryRefreshStore : Initialize new in-memory refresh token store
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens:   make(map[string]RefreshToken),
+		families: make(map[string][]string),
+	}
+}
+
+// Save stores a new refresh token and evicts expired ones
+func (s *MemoryRefreshStore) Save(_ context.Context, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for familyID, hashes := range s.families {
+		if last, ok := s.tokens[hashes[len(hashes)-1]]; ok && now.After(last.ExpiresAt) {
+			s.revokeFamily(familyID)
+		}
+	}
+
+	s.tokens[token.Hash] = token
+	s.families[token.FamilyID] = append(s.families[token.FamilyID], token.Hash)
+
+	return nil
+}
+
+// Consume marks the token as used and returns it as it was before the call
+func (s *MemoryRefreshStore) Consume(_ context.Context, hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+
+	used := token
+	used.Used = true
+	s.tokens[hash] = used
+
+	return token, nil
+}
+
+// RevokeFamily deletes every token of the family
+func (s *MemoryRefreshStore) RevokeFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeFamily(familyID)
+	return nil
+}
+
+func (s *MemoryRefreshStore) revokeFamily(familyID string) {
+	for _, hash := range s.families[familyID] {
+		delete(s.tokens, hash)
+	}
+	delete(s.families, familyID)
+}
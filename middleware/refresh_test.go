@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshTokenManager_Rotate(t *testing.T) {
+	ctx := context.Background()
+	manager := middleware.NewRefreshTokenManager(middleware.NewMemoryRefreshStore(), time.Hour)
+
+	first, err := manager.Issue(ctx, "user-1")
+	require.NoError(t, err)
+
+	second, subject, err := manager.Rotate(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", subject)
+	assert.NotEqual(t, first, second)
+
+	third, subject, err := manager.Rotate(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", subject)
+
+	// Replaying a rotated token revokes the whole family, including the latest token
+	_, _, err = manager.Rotate(ctx, first)
+	assert.Equal(t, common.ErrInvalidToken, err)
+
+	_, _, err = manager.Rotate(ctx, third)
+	assert.Equal(t, common.ErrInvalidToken, err)
+}
+
+func TestRefreshTokenManager_FamiliesAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	manager := middleware.NewRefreshTokenManager(middleware.NewMemoryRefreshStore(), time.Hour)
+
+	stolen, err := manager.Issue(ctx, "user-1")
+	require.NoError(t, err)
+	otherDevice, err := manager.Issue(ctx, "user-1")
+	require.NoError(t, err)
+
+	_, _, err = manager.Rotate(ctx, stolen)
+	require.NoError(t, err)
+	_, _, err = manager.Rotate(ctx, stolen)
+	assert.Equal(t, common.ErrInvalidToken, err)
+
+	_, _, err = manager.Rotate(ctx, otherDevice)
+	assert.NoError(t, err)
+}
+
+func TestRefreshTokenManager_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		token   func(m *middleware.RefreshTokenManager) string
+		wantErr error
+	}{
+		{
+			name: "error - unknown token",
+			ttl:  time.Hour,
+			token: func(*middleware.RefreshTokenManager) string {
+				return "unknown"
+			},
+			wantErr: common.ErrInvalidToken,
+		},
+		{
+			name: "error - expired token",
+			ttl:  time.Millisecond,
+			token: func(m *middleware.RefreshTokenManager) string {
+				token, err := m.Issue(ctx, "user-1")
+				require.NoError(t, err)
+				time.Sleep(5 * time.Millisecond)
+				return token
+			},
+			wantErr: common.ErrSessionExpired,
+		},
+		{
+			name: "error - revoked token",
+			ttl:  time.Hour,
+			token: func(m *middleware.RefreshTokenManager) string {
+				token, err := m.Issue(ctx, "user-1")
+				require.NoError(t, err)
+				require.NoError(t, m.Revoke(ctx, token))
+				return token
+			},
+			wantErr: common.ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := middleware.NewRefreshTokenManager(middleware.NewMemoryRefreshStore(), tt.ttl)
+			_, _, err := manager.Rotate(ctx, tt.token(manager))
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultReplayCacheCapacity = 100_000
+
+// ErrReplayCacheFull is returned by MemoryReplayCache when every recorded key is still within its TTL
+var ErrReplayCacheFull = errors.New("replay cache full")
+
+// ReplayCache remembers accepted signatures for as long as they could be replayed
+type ReplayCache interface {
+	// Seen atomically records key for ttl and reports whether it was already recorded and not yet expired
+	Seen(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// WithReplayCache : Reject signatures that were already accepted for the same request with common.ErrSignatureReplayed.
+// The key covers the method, path, query and body, so distinct requests of a client sharing a v1 signature are accepted.
+// A failing cache responds with common.ErrServerError.
+func WithReplayCache(cache ReplayCache) AggregatorOption {
+	return func(cfg *aggregatorConfig) {
+		cfg.replayCache = cache
+	}
+}
+
+// replayKey identifies a signature of a client for a request without storing the signature itself
+func replayKey(clientID, signature string, req SignatureRequest) string {
+	h := sha256.New()
+	for _, part := range []string{signature, req.Method, req.Path, req.Query} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(req.Body)
+	return clientID + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// MemoryReplayCache is an in-memory ReplayCache with TTL expiry.
+// Keys are never evicted before they expire, so replays stay rejected: when capacity keys are recorded
+// and none expired, Seen fails with ErrReplayCacheFull until one does. Size capacity for the request rate
+// times the replay window, e.g. 100000 keys hold about 280 requests per second over a 6 minute window.
+type MemoryReplayCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type replayEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// NewMemoryReplayCache : Initialize new in-memory replay cache holding at most capacity keys (default 100000)
+func NewMemoryReplayCache(capacity int) *MemoryReplayCache {
+	if capacity <= 0 {
+		capacity = defaultReplayCacheCapacity
+	}
+
+	return &MemoryReplayCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Seen implements ReplayCache
+func (m *MemoryReplayCache) Seen(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := m.entries[key]; ok {
+		if now.Before(el.Value.(*replayEntry).expiresAt) {
+			return true, nil
+		}
+		m.remove(el)
+	}
+
+	// Expired keys sit at the back since they were recorded first
+	for el := m.order.Back(); el != nil && !now.Before(el.Value.(*replayEntry).expiresAt); el = m.order.Back() {
+		m.remove(el)
+	}
+	if m.order.Len() >= m.capacity {
+		// Keys recorded with a shorter TTL may have expired ahead of the back
+		for el := m.order.Back(); el != nil; {
+			prev := el.Prev()
+			if !now.Before(el.Value.(*replayEntry).expiresAt) {
+				m.remove(el)
+			}
+			el = prev
+		}
+		if m.order.Len() >= m.capacity {
+			return false, ErrReplayCacheFull
+		}
+	}
+
+	m.entries[key] = m.order.PushFront(&replayEntry{key: key, expiresAt: now.Add(ttl)})
+	return false, nil
+}
+
+// Len returns the number of recorded keys
+func (m *MemoryReplayCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+func (m *MemoryReplayCache) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.entries, el.Value.(*replayEntry).key)
+}
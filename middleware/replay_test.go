@@ -0,0 +1,141 @@
+package middleware_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("records keys until they expire", func(t *testing.T) {
+		cache := middleware.NewMemoryReplayCache(10)
+
+		seen, err := cache.Seen(ctx, "a", 20*time.Millisecond)
+		require.NoError(t, err)
+		assert.False(t, seen)
+
+		seen, err = cache.Seen(ctx, "a", 20*time.Millisecond)
+		require.NoError(t, err)
+		assert.True(t, seen)
+
+		time.Sleep(30 * time.Millisecond)
+		seen, err = cache.Seen(ctx, "a", 20*time.Millisecond)
+		require.NoError(t, err)
+		assert.False(t, seen)
+	})
+
+	t.Run("fails when full of unexpired keys", func(t *testing.T) {
+		cache := middleware.NewMemoryReplayCache(2)
+		for _, key := range []string{"a", "b"} {
+			_, err := cache.Seen(ctx, key, time.Minute)
+			require.NoError(t, err)
+		}
+
+		_, err := cache.Seen(ctx, "c", time.Minute)
+		assert.ErrorIs(t, err, middleware.ErrReplayCacheFull)
+		assert.Equal(t, 2, cache.Len())
+
+		seen, err := cache.Seen(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, seen, "recorded keys are kept while the cache is full")
+	})
+
+	t.Run("expired keys make room", func(t *testing.T) {
+		cache := middleware.NewMemoryReplayCache(2)
+		_, err := cache.Seen(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		_, err = cache.Seen(ctx, "b", 10*time.Millisecond)
+		require.NoError(t, err)
+
+		time.Sleep(20 * time.Millisecond)
+		seen, err := cache.Seen(ctx, "c", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, seen)
+		assert.Equal(t, 2, cache.Len())
+	})
+}
+
+func TestValidateAggregatorSignature_Replay(t *testing.T) {
+	token := clientToken(t)
+	app := signatureApp(middleware.WithReplayCache(middleware.NewMemoryReplayCache(0)))
+
+	send := func(signature, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/wallet/debit", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set("X-Aggregator-Signature", signature)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	body := `{"amount":100}`
+	signature := v2Signature(middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit", Body: []byte(body)}, clientSecret, time.Now().Unix())
+
+	resp := send(signature, body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = send(signature, body)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, common.ErrSignatureReplayed.Code, responseCode(t, resp))
+
+	// Invalid signatures are not recorded, so a failed attempt does not block the genuine request
+	other := `{"amount":200}`
+	otherSignature := v2Signature(middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit", Body: []byte(other)}, clientSecret, time.Now().Unix())
+	resp = send(otherSignature, body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = send(otherSignature, other)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestValidateAggregatorSignature_ReplayLegacy(t *testing.T) {
+	token := clientToken(t)
+	app := signatureApp(middleware.WithReplayCache(middleware.NewMemoryReplayCache(0)))
+
+	// v1 signatures only cover the token and the second, the replay key tells distinct requests apart
+	signature := legacySignature(t, token, time.Now().Unix())
+	send := func(body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/wallet/debit", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set("X-Aggregator-Signature", signature)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send(`{"amount":100}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = send(`{"amount":100}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, common.ErrSignatureReplayed.Code, responseCode(t, resp))
+
+	resp = send(`{"amount":200}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestValidateAggregatorSignature_ReplayCacheFull(t *testing.T) {
+	token := clientToken(t)
+	app := signatureApp(middleware.WithReplayCache(middleware.NewMemoryReplayCache(1)))
+
+	signature := legacySignature(t, token, time.Now().Unix())
+	for i, want := range []int{http.StatusOK, http.StatusInternalServerError} {
+		req := httptest.NewRequest(http.MethodPost, "/wallet/debit", strings.NewReader(fmt.Sprintf(`{"amount":%d}`, i)))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set("X-Aggregator-Signature", signature)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, want, resp.StatusCode)
+	}
+}
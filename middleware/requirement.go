@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Requirement is an authorization requirement on the caller of a request, built with RequirePermission,
+// RequireAction, RequireRole and combined with RequireAll, RequireAny and Not.
+// An error means no decision could be made, the request is denied.
+type Requirement func(c *fiber.Ctx, claims *types.JWTClaims) (Decision, error)
+
+// RequirePermission : Require the action of the route on permission, as ValidatePermission does
+func RequirePermission(permission types.Permission) Requirement {
+	return RequirePermissionWith(DefaultPolicyEvaluator, permission)
+}
+
+// RequirePermissionWith : Same as RequirePermission, decisions are made by evaluator
+func RequirePermissionWith(evaluator PolicyEvaluator, permission types.Permission) Requirement {
+	mustEvaluator(evaluator, "RequirePermissionWith")
+	mustLookupPermission(permission)
+
+	return func(c *fiber.Ctx, claims *types.JWTClaims) (Decision, error) {
+		action, ok := routeAction(c)
+		if !ok {
+			return Decision{Reason: "method not mapped to action", checks: []permissionCheck{{permission: permission}}}, nil
+		}
+
+		return evaluate(c, evaluator, claims, permission, action)
+	}
+}
+
+// RequireAction : Require action on permission regardless of the method, e.g. RequireAction(types.PermissionSettings, types.ActionWrite)
+func RequireAction(permission types.Permission, action types.PermissionAction) Requirement {
+	return RequireActionWith(DefaultPolicyEvaluator, permission, action)
+}
+
+// RequireActionWith : Same as RequireAction, decisions are made by evaluator
+func RequireActionWith(evaluator PolicyEvaluator, permission types.Permission, action types.PermissionAction) Requirement {
+	mustEvaluator(evaluator, "RequireActionWith")
+	def := mustLookupPermission(permission)
+	if action == 0 || action&^def.Actions != 0 {
+		panic(fmt.Sprintf("requirement: action %v not declared by permission %s", action.Names(), def.Key))
+	}
+
+	return func(c *fiber.Ctx, claims *types.JWTClaims) (Decision, error) {
+		return evaluate(c, evaluator, claims, def.Key, action)
+	}
+}
+
+// RequireRole : Require the caller to have one of roles
+func RequireRole(roles ...types.Role) Requirement {
+	if len(roles) == 0 {
+		panic("requirement: RequireRole without role")
+	}
+
+	return func(_ *fiber.Ctx, claims *types.JWTClaims) (Decision, error) {
+		for _, role := range roles {
+			if claims.Type == role {
+				return Decision{Allowed: true, Reason: fmt.Sprintf("role is %s", role)}, nil
+			}
+		}
+
+		return Decision{Reason: fmt.Sprintf("role %s not in %v", claims.Type, roles)}, nil
+	}
+}
+
+// RequireAll : Require every requirement, evaluation stops at the first one not met
+func RequireAll(requirements ...Requirement) Requirement {
+	if len(requirements) == 0 {
+		panic("requirement: RequireAll without requirement")
+	}
+
+	return func(c *fiber.Ctx, claims *types.JWTClaims) (Decision, error) {
+		reasons := make([]string, 0, len(requirements))
+		var checks []permissionCheck
+		for _, requirement := range requirements {
+			decision, err := requirement(c, claims)
+			if err != nil || !decision.Allowed {
+				return decision, err
+			}
+			reasons = append(reasons, decision.Reason)
+			checks = append(checks, decision.checks...)
+		}
+
+		return Decision{Allowed: true, Reason: joinReasons(reasons, " and "), checks: checks}, nil
+	}
+}
+
+// RequireAny : Require at least one requirement, evaluation stops at the first one met
+func RequireAny(requirements ...Requirement) Requirement {
+	if len(requirements) == 0 {
+		panic("requirement: RequireAny without requirement")
+	}
+
+	return func(c *fiber.Ctx, claims *types.JWTClaims) (Decision, error) {
+		reasons := make([]string, 0, len(requirements))
+		stepUp := false
+		var checks []permissionCheck
+		for _, requirement := range requirements {
+			decision, err := requirement(c, claims)
+			if err != nil || decision.Allowed {
+				return decision, err
+			}
+			reasons = append(reasons, decision.Reason)
+			stepUp = stepUp || decision.stepUp
+			checks = append(checks, decision.checks...)
+		}
+
+		// A more recent authentication lifts the denial when it would lift one of the alternatives
+		return Decision{Reason: joinReasons(reasons, " or "), stepUp: stepUp, checks: checks}, nil
+	}
+}
+
+// Not : Require requirement not to be met, e.g. Not(RequireRole(types.RoleClient)).
+// An evaluation error or a step-up denial still denies the request, the caller may hold the permission.
+func Not(requirement Requirement) Requirement {
+	return func(c *fiber.Ctx, claims *types.JWTClaims) (Decision, error) {
+		decision, err := requirement(c, claims)
+		if err != nil {
+			return Decision{checks: decision.checks}, err
+		}
+		if decision.stepUp {
+			return decision, nil
+		}
+
+		return Decision{Allowed: !decision.Allowed, Reason: "not (" + decision.Reason + ")", checks: decision.checks}, nil
+	}
+}
+
+// Handler : Check the requirement on the claims set by NewAuthMiddleware,
+// with the same responses as ValidatePermission.
+// e.g. app.Get("/reports", auth, RequireAll(RequirePermission(types.PermissionReportProfit), RequirePermission(types.PermissionReportClients)).Handler(), handler)
+func (r Requirement) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := userClaims(c, "Requirement")
+		if !ok {
+			auditUnauthenticated(c)
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		}
+
+		decision, err := r(c, claims)
+		event := decision.auditEvent()
+		if err != nil {
+			slog.Warn("Requirement evaluation failed", "userID", claims.ID, "method", c.Method(), "path", c.Path(), "error", err.Error())
+			event.Outcome, event.Reason = AuditError, err.Error()
+			audit(c, claims, event)
+			return common.Response().SetError(common.ErrServerError).Send(c)
+		}
+		event.Reason = decision.Reason
+		if !decision.Allowed {
+			slog.Info("Requirement denied", "userID", claims.ID, "method", c.Method(), "path", c.Path(), "reason", decision.Reason)
+			event.Outcome = AuditDenied
+			audit(c, claims, event)
+			return common.Response().SetError(denialError(decision)).Send(c)
+		}
+
+		slog.Debug("Requirement granted", "userID", claims.ID, "method", c.Method(), "path", c.Path(), "reason", decision.Reason)
+		event.Outcome = AuditGranted
+		audit(c, claims, event)
+		return c.Next()
+	}
+}
+
+// evaluate asks evaluator whether claims may perform action on permission, including its step-up requirement
+func evaluate(c *fiber.Ctx, evaluator PolicyEvaluator, claims *types.JWTClaims, permission types.Permission, action types.PermissionAction) (Decision, error) {
+	decision, err := evaluateStepUp(c.Context(), evaluator, claims, AuthzRequest{
+		Principal:  claims,
+		Action:     action,
+		Resource:   permission,
+		Attributes: authzAttributes(c),
+	})
+	decision.checks = []permissionCheck{{permission: permission, action: action}}
+
+	return decision, err
+}
+
+// auditEvent returns an event with the permissions checked for the decision joined with "," and their actions
+func (d Decision) auditEvent() AuditEvent {
+	var event AuditEvent
+	var actions types.PermissionAction
+	seen := make(map[types.Permission]bool, len(d.checks))
+	permissions := make([]string, 0, len(d.checks))
+	for _, check := range d.checks {
+		actions |= check.action
+		if !seen[check.permission] {
+			seen[check.permission] = true
+			permissions = append(permissions, string(check.permission))
+		}
+	}
+	event.Permission = strings.Join(permissions, ",")
+	if actions != 0 {
+		event.Actions = actions.Names()
+	}
+
+	return event
+}
+
+func mustLookupPermission(permission types.Permission) types.PermissionDefinition {
+	def, ok := types.LookupPermission(string(permission))
+	if !ok {
+		panic(fmt.Sprintf("requirement: unknown permission %q", permission))
+	}
+
+	return def
+}
+
+func joinReasons(reasons []string, sep string) string {
+	if len(reasons) == 1 {
+		return reasons[0]
+	}
+
+	return "(" + strings.Join(reasons, sep) + ")"
+}
@@ -0,0 +1,163 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequirement_Handler(t *testing.T) {
+	token := func(role types.Role, perms types.PermissionsDTO) string {
+		return signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+			c.Type = role
+			c.Permissions = perms
+		}))
+	}
+	profitOnly := token(types.RoleUser, types.PermissionsDTO{types.PermissionReportProfit: types.ActionRead})
+	reporter := token(types.RoleUser, types.PermissionsDTO{types.PermissionReportProfit: types.ActionRead, types.PermissionReportClients: types.ActionRead})
+	settingsWriter := token(types.RoleUser, types.PermissionsDTO{types.PermissionSettings: types.ActionWrite})
+	admin := token(types.RoleAdmin, nil)
+	client := token(types.RoleClient, nil)
+
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	auth := middleware.NewAuthMiddleware("secret")
+	app := fiber.New()
+	app.Get("/reports", auth, middleware.RequireAll(
+		middleware.RequirePermission(types.PermissionReportProfit),
+		middleware.RequirePermission(types.PermissionReportClients),
+	).Handler(), ok)
+	app.Get("/settings", auth, middleware.RequireAny(
+		middleware.RequireRole(types.RoleAdmin),
+		middleware.RequireAction(types.PermissionSettings, types.ActionWrite),
+	).Handler(), ok)
+	app.Get("/games", auth, middleware.Not(middleware.RequireRole(types.RoleClient)).Handler(), ok)
+	app.Get("/anonymous", middleware.RequireRole(types.RoleAdmin).Handler(), ok)
+
+	tests := []struct {
+		name       string
+		target     string
+		token      string
+		wantStatus int
+		wantCode   int
+	}{
+		{name: "success - all permissions granted", target: "/reports", token: reporter, wantStatus: http.StatusOK},
+		{name: "error - one permission missing", target: "/reports", token: profitOnly, wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "success - any by role", target: "/settings", token: admin, wantStatus: http.StatusOK},
+		{name: "success - any by action regardless of method", target: "/settings", token: settingsWriter, wantStatus: http.StatusOK},
+		{name: "error - none met", target: "/settings", token: reporter, wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "success - not client", target: "/games", token: reporter, wantStatus: http.StatusOK},
+		{name: "error - negated role", target: "/games", token: client, wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "error - no claims", target: "/anonymous", wantStatus: http.StatusUnauthorized, wantCode: common.ErrUnauthorized.Code},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			}
+		})
+	}
+}
+
+func TestRequirement_Reasons(t *testing.T) {
+	sink := middleware.NewMemoryAuditSink()
+	useAuditSink(t, sink)
+
+	app := fiber.New()
+	app.Get("/settings", middleware.NewAuthMiddleware("secret"), middleware.RequireAny(
+		middleware.RequireRole(types.RoleAdmin),
+		middleware.RequireAll(
+			middleware.RequirePermission(types.PermissionSettings),
+			middleware.Not(middleware.RequireRole(types.RoleClient)),
+		),
+	).Handler(), func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Type = types.RoleUser
+		c.Permissions = types.PermissionsDTO{types.PermissionSettings: types.ActionRead}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	events := sink.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, middleware.AuditGranted, events[0].Outcome)
+	assert.Equal(t, "(settings grants [read] and not (role user not in [client]))", events[0].Reason)
+	assert.Equal(t, "settings", events[0].Permission)
+	assert.Equal(t, []string{"read"}, events[0].Actions)
+}
+
+func TestRequirement_AuditDenied(t *testing.T) {
+	sink := middleware.NewMemoryAuditSink()
+	useAuditSink(t, sink)
+
+	app := fiber.New()
+	app.Post("/reports", middleware.NewAuthMiddleware("secret"), middleware.RequireAny(
+		middleware.RequireRole(types.RoleAdmin),
+		middleware.RequirePermission(types.PermissionReportProfit),
+		middleware.RequireAction(types.PermissionReportClients, types.ActionRead),
+	).Handler(), func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodPost, "/reports", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+		c.Type = types.RoleUser
+	})))
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	events := sink.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, middleware.AuditDenied, events[0].Outcome)
+	assert.Equal(t, "report_profit,report_clients", events[0].Permission)
+	assert.Equal(t, []string{"read", "write"}, events[0].Actions)
+}
+
+func TestRequirement_EvaluatorError(t *testing.T) {
+	failing := middleware.PolicyEvaluatorFunc(func(context.Context, middleware.AuthzRequest) (middleware.Decision, error) {
+		return middleware.Decision{}, errors.New("policy store unavailable")
+	})
+
+	app := fiber.New()
+	app.Get("/games", middleware.NewAuthMiddleware("secret"),
+		middleware.Not(middleware.RequirePermissionWith(failing, types.PermissionGames)).Handler(),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/games", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims()))
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Not must not turn an error into a grant")
+	assert.Equal(t, common.ErrServerError.Code, responseCode(t, resp))
+}
+
+func TestRequirement_Invalid(t *testing.T) {
+	assert.Panics(t, func() { middleware.RequirePermission("unknown") })
+	assert.Panics(t, func() { middleware.RequirePermissionWith(nil, types.PermissionGames) })
+	assert.Panics(t, func() { middleware.RequireAction(types.PermissionGames, 0) })
+	assert.Panics(t, func() { middleware.RequireAction(types.PermissionGames, actionApprove) })
+	assert.Panics(t, func() { middleware.RequireRole() })
+	assert.Panics(t, func() { middleware.RequireAll() })
+	assert.Panics(t, func() { middleware.RequireAny() })
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errTokenRevoked    = errors.New("token has been revoked")
+	errRevocationCheck = errors.New("cannot check token revocation")
+)
+
+// revokedForever is the cutoff stored for subjects whose tokens are all revoked, including future ones
+var revokedForever = time.Unix(1<<62, 0)
+
+// RevocationChecker reports whether a verified token has been revoked before its expiry.
+// jti is the "jti" claim, subject is the principal id and issuedAt is the "iat" claim (zero when absent).
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error)
+}
+
+// WithRevocationChecker : Reject verified tokens reported as revoked by checker with common.ErrInvalidToken
+func WithRevocationChecker(checker RevocationChecker) AuthOption {
+	return func(cfg *authConfig) {
+		cfg.revocation = checker
+	}
+}
+
+// MemoryRevocationStore is an in-memory RevocationChecker. Entries are evicted once their ttl has passed,
+// which should be at least the lifetime of the tokens they revoke.
+type MemoryRevocationStore struct {
+	mu       sync.Mutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectRevocation
+}
+
+type subjectRevocation struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// NewMemoryRevocationStore : Initialize new in-memory revocation store
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+// RevokeToken revokes the token with the given jti for ttl
+func (s *MemoryRevocationStore) RevokeToken(jti string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+	s.tokens[jti] = time.Now().Add(ttl)
+}
+
+// RevokeSubject revokes every token of subject, including tokens issued later, for ttl
+func (s *MemoryRevocationStore) RevokeSubject(subject string, ttl time.Duration) {
+	s.RevokeIssuedBefore(subject, revokedForever, ttl)
+}
+
+// RevokeIssuedBefore revokes the tokens of subject issued before the given time for ttl
+func (s *MemoryRevocationStore) RevokeIssuedBefore(subject string, before time.Time, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+	s.subjects[subject] = subjectRevocation{before: before, expiresAt: time.Now().Add(ttl)}
+}
+
+// IsRevoked implements RevocationChecker
+func (s *MemoryRevocationStore) IsRevoked(_ context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := s.tokens[jti]; ok && jti != "" && now.Before(expiresAt) {
+		return true, nil
+	}
+	if rev, ok := s.subjects[subject]; ok && subject != "" && now.Before(rev.expiresAt) {
+		return issuedAt.Before(rev.before), nil
+	}
+
+	return false, nil
+}
+
+func (s *MemoryRevocationStore) evict() {
+	now := time.Now()
+	for jti, expiresAt := range s.tokens {
+		if !now.Before(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for subject, rev := range s.subjects {
+		if !now.Before(rev.expiresAt) {
+			delete(s.subjects, subject)
+		}
+	}
+}
+
+// KeyValueStore is the subset of a Valkey/Redis compatible client used by KVRevocationStore.
+// Get returns ok false when the key does not exist.
+type KeyValueStore interface {
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+// KVRevocationStore is a RevocationChecker backed by a shared key-value store, expiry is left to the store
+type KVRevocationStore struct {
+	kv     KeyValueStore
+	prefix string
+}
+
+// NewKVRevocationStore : Initialize new revocation store writing keys under prefix, e.g. "auth:revoked:"
+func NewKVRevocationStore(kv KeyValueStore, prefix string) *KVRevocationStore {
+	return &KVRevocationStore{kv: kv, prefix: prefix}
+}
+
+// RevokeToken revokes the token with the given jti for ttl
+func (s *KVRevocationStore) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return s.kv.Set(ctx, s.prefix+"jti:"+jti, "1", ttl)
+}
+
+// RevokeSubject revokes every token of subject, including tokens issued later, for ttl
+func (s *KVRevocationStore) RevokeSubject(ctx context.Context, subject string, ttl time.Duration) error {
+	return s.RevokeIssuedBefore(ctx, subject, revokedForever, ttl)
+}
+
+// RevokeIssuedBefore revokes the tokens of subject issued before the given time for ttl.
+// The "iat" claim has a precision of one second, the cutoff is stored rounded up to the next second
+// so tokens issued earlier in the same second are revoked, as with MemoryRevocationStore.
+func (s *KVRevocationStore) RevokeIssuedBefore(ctx context.Context, subject string, before time.Time, ttl time.Duration) error {
+	cutoff := before.Unix()
+	if before.After(time.Unix(cutoff, 0)) {
+		cutoff++
+	}
+
+	return s.kv.Set(ctx, s.prefix+"sub:"+subject, strconv.FormatInt(cutoff, 10), ttl)
+}
+
+// IsRevoked implements RevocationChecker
+func (s *KVRevocationStore) IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		_, ok, err := s.kv.Get(ctx, s.prefix+"jti:"+jti)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	if subject != "" {
+		value, ok, err := s.kv.Get(ctx, s.prefix+"sub:"+subject)
+		if err != nil || !ok {
+			return false, err
+		}
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedAt.Unix() < before, nil
+	}
+
+	return false, nil
+}
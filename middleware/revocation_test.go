@@ -0,0 +1,127 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapKV is a KeyValueStore stand-in for a Valkey/Redis client
+type mapKV struct {
+	mu     sync.Mutex
+	values map[string]string
+	err    error
+}
+
+func (m *mapKV) Get(_ context.Context, key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return "", false, m.err
+	}
+	v, ok := m.values[key]
+	return v, ok, nil
+}
+
+func (m *mapKV) Set(_ context.Context, key, value string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = make(map[string]string)
+	}
+	m.values[key] = value
+	return nil
+}
+
+func responseCode(t *testing.T, resp *http.Response) int {
+	t.Helper()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var payload struct {
+		Code int `json:"code"`
+	}
+	_ = json.Unmarshal(body, &payload)
+
+	return payload.Code
+}
+
+func TestNewAuthMiddleware_Revocation(t *testing.T) {
+	issuer, err := middleware.NewIssuer(middleware.IssuerConfig{Secret: "secret"})
+	require.NoError(t, err)
+
+	issue := func(userID, jti string, issuedAt time.Time) string {
+		token, err := issuer.IssueUserToken(types.JWTClaims{
+			ID:               userID,
+			RegisteredClaims: jwt.RegisteredClaims{ID: jti, IssuedAt: jwt.NewNumericDate(issuedAt)},
+		})
+		require.NoError(t, err)
+		return token
+	}
+
+	memory := middleware.NewMemoryRevocationStore()
+	memory.RevokeToken("revoked-jti", time.Hour)
+	memory.RevokeSubject("suspended-user", time.Hour)
+	memory.RevokeIssuedBefore("changed-user", time.Now().Add(-time.Minute), time.Hour)
+	// "iat" is truncated to the second, a token issued earlier in the cutoff second must be revoked
+	sameSecond := time.Now().Add(-time.Minute).Truncate(time.Second).Add(500 * time.Millisecond)
+	memory.RevokeIssuedBefore("same-second-user", sameSecond, time.Hour)
+	memory.RevokeToken("evicted-jti", -time.Second)
+
+	kv := middleware.NewKVRevocationStore(&mapKV{}, "auth:revoked:")
+	require.NoError(t, kv.RevokeToken(context.Background(), "revoked-jti", time.Hour))
+	require.NoError(t, kv.RevokeSubject(context.Background(), "suspended-user", time.Hour))
+	require.NoError(t, kv.RevokeIssuedBefore(context.Background(), "changed-user", time.Now().Add(-time.Minute), time.Hour))
+	require.NoError(t, kv.RevokeIssuedBefore(context.Background(), "same-second-user", sameSecond, time.Hour))
+
+	stores := map[string]middleware.RevocationChecker{"memory": memory, "kv": kv}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{name: "success - not revoked", token: issue("user-1", "jti-1", time.Now()), wantCode: 0},
+		{name: "error - revoked jti", token: issue("user-1", "revoked-jti", time.Now()), wantCode: common.ErrInvalidToken.Code},
+		{name: "error - revoked subject", token: issue("suspended-user", "jti-2", time.Now()), wantCode: common.ErrInvalidToken.Code},
+		{name: "error - issued before cutoff", token: issue("changed-user", "jti-3", time.Now().Add(-2*time.Minute)), wantCode: common.ErrInvalidToken.Code},
+		{name: "success - issued after cutoff", token: issue("changed-user", "jti-4", time.Now()), wantCode: 0},
+		{name: "error - issued earlier in the cutoff second", token: issue("same-second-user", "jti-6", sameSecond.Add(-200*time.Millisecond)), wantCode: common.ErrInvalidToken.Code},
+		{name: "success - issued the second after the cutoff", token: issue("same-second-user", "jti-7", sameSecond.Add(time.Second)), wantCode: 0},
+	}
+
+	for storeName, store := range stores {
+		app := protectedApp(middleware.NewAuthMiddleware("secret", middleware.WithRevocationChecker(store)))
+		for _, tt := range tests {
+			t.Run(storeName+" "+tt.name, func(t *testing.T) {
+				resp := doRequest(t, app, tt.token)
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			})
+		}
+	}
+
+	t.Run("memory success - evicted entry", func(t *testing.T) {
+		app := protectedApp(middleware.NewAuthMiddleware("secret", middleware.WithRevocationChecker(memory)))
+		resp := doRequest(t, app, issue("user-1", "evicted-jti", time.Now()))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("kv error - store unavailable", func(t *testing.T) {
+		broken := middleware.NewKVRevocationStore(&mapKV{err: errors.New("connection refused")}, "auth:revoked:")
+		app := protectedApp(middleware.NewAuthMiddleware("secret", middleware.WithRevocationChecker(broken)))
+		resp := doRequest(t, app, issue("user-1", "jti-5", time.Now()))
+		assert.Equal(t, common.ErrServerError.Code, responseCode(t, resp))
+	})
+}
@@ -4,12 +4,20 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// SignatureV2Prefix marks a v2 X-Aggregator-Signature header: "v2;t=<unix timestamp>;sig=<base64 HMAC-SHA256>"
+const SignatureV2Prefix = "v2;"
+
 // SignaturePayload represents the internal data structure encoded in the signature
 type SignaturePayload struct {
 	Token     string `json:"token"`
@@ -17,29 +25,109 @@ type SignaturePayload struct {
 	Hash      string `json:"hash"`
 }
 
+const (
+	defaultSignatureMaxAge = 5 * time.Minute
+	defaultSignatureSkew   = 60 * time.Second
+)
+
+// Signature validation errors, match them with errors.Is
+var (
+	ErrSignatureMalformed     = errors.New("invalid signature format")
+	ErrSignatureExpired       = errors.New("signature expired")
+	ErrSignatureFromFuture    = errors.New("invalid timestamp: timestamp from future")
+	ErrSignatureMismatch      = errors.New("invalid signature: hash mismatch")
+	ErrSignatureMissingSecret = errors.New("invalid signature: missing client secret")
+)
+
+var defaultSignatureValidator = NewSignatureValidator()
+
+// SignatureValidator validates X-Aggregator-Signature headers within a validity window
+type SignatureValidator struct {
+	maxAge time.Duration
+	skew   time.Duration
+	now    func() time.Time
+	header string
+}
+
+// SignatureValidatorOption configures a SignatureValidator
+type SignatureValidatorOption func(*SignatureValidator)
+
+// WithSignatureMaxAge : Reject signatures older than maxAge. Default: 5 minutes
+func WithSignatureMaxAge(maxAge time.Duration) SignatureValidatorOption {
+	return func(v *SignatureValidator) {
+		v.maxAge = maxAge
+	}
+}
+
+// WithSignatureSkew : Accept signatures timestamped up to skew in the future. Default: 60 seconds
+func WithSignatureSkew(skew time.Duration) SignatureValidatorOption {
+	return func(v *SignatureValidator) {
+		v.skew = skew
+	}
+}
+
+// WithSignatureClock : Use now as the clock source instead of time.Now
+func WithSignatureClock(now func() time.Time) SignatureValidatorOption {
+	return func(v *SignatureValidator) {
+		v.now = now
+	}
+}
+
+// WithSignatureHeader : Read the signature from the given header. Default: X-Aggregator-Signature
+func WithSignatureHeader(header string) SignatureValidatorOption {
+	return func(v *SignatureValidator) {
+		v.header = header
+	}
+}
+
+// NewSignatureValidator : Initialize new signature validator
+func NewSignatureValidator(opts ...SignatureValidatorOption) *SignatureValidator {
+	v := &SignatureValidator{
+		maxAge: defaultSignatureMaxAge,
+		skew:   defaultSignatureSkew,
+		now:    time.Now,
+		header: SignatureHeader,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// Header returns the name of the header carrying the signature
+func (v *SignatureValidator) Header() string {
+	return v.header
+}
+
+// ReplayWindow returns how long a signature can be accepted, from the allowed skew until it expires
+func (v *SignatureValidator) ReplayWindow() time.Duration {
+	return v.maxAge + v.skew
+}
+
 // ValidateSignature validates signature and extracts its data
 func ValidateSignature(signatureB64 string) error {
+	return defaultSignatureValidator.Validate(signatureB64)
+}
+
+// Validate validates a v1 signature
+func (v *SignatureValidator) Validate(signatureB64 string) error {
 	jsonData, err := base64.StdEncoding.DecodeString(signatureB64)
 	if err != nil {
-		return fmt.Errorf("invalid signature format: cannot decode base64: %w", err)
+		return fmt.Errorf("%w: cannot decode base64: %w", ErrSignatureMalformed, err)
 	}
 
 	var payload SignaturePayload
 	if err := json.Unmarshal(jsonData, &payload); err != nil {
-		return fmt.Errorf("invalid signature format: cannot parse JSON: %w", err)
+		return fmt.Errorf("%w: cannot parse JSON: %w", ErrSignatureMalformed, err)
 	}
 
 	if payload.Token == "" || payload.Timestamp == 0 || payload.Hash == "" {
-		return errors.New("invalid signature payload: missing fields")
+		return fmt.Errorf("%w: missing fields", ErrSignatureMalformed)
 	}
 
-	now := time.Now().Unix()
-	expiration := int64(5 * 60)
-	if now-payload.Timestamp > expiration {
-		return fmt.Errorf("signature expired: %d seconds old", now-payload.Timestamp)
-	}
-	if payload.Timestamp > now+60 {
-		return errors.New("invalid timestamp: timestamp from future")
+	if err := v.checkTimestamp(payload.Timestamp); err != nil {
+		return err
 	}
 
 	data := fmt.Sprintf("%s%d", payload.Token, payload.Timestamp)
@@ -48,7 +136,125 @@ func ValidateSignature(signatureB64 string) error {
 	expectedHash := base64.StdEncoding.EncodeToString(h.Sum(nil))
 
 	if !hmac.Equal([]byte(payload.Hash), []byte(expectedHash)) {
-		return errors.New("invalid signature: hash mismatch")
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
+
+// checkTimestamp verifies the signature timestamp is within the validity window
+func (v *SignatureValidator) checkTimestamp(timestamp int64) error {
+	age := v.now().Sub(time.Unix(timestamp, 0))
+	if age > v.maxAge {
+		return fmt.Errorf("%w: %d seconds old", ErrSignatureExpired, int64(age.Seconds()))
+	}
+	if -age > v.skew {
+		return ErrSignatureFromFuture
+	}
+
+	return nil
+}
+
+// SignatureRequest is the part of an HTTP request covered by a v2 signature
+type SignatureRequest struct {
+	Method string
+	Path   string
+	Query  string // raw query string, without the leading "?"
+	Body   []byte
+}
+
+// IsSignatureV2 reports whether the header value uses the v2 signature scheme
+func IsSignatureV2(signature string) bool {
+	return strings.HasPrefix(signature, SignatureV2Prefix)
+}
+
+// CanonicalSignatureString builds the string signed by a v2 signature:
+// version, method, path, canonical query, hex SHA-256 of the body and timestamp, separated by new lines
+func CanonicalSignatureString(req SignatureRequest, timestamp int64) string {
+	bodyHash := sha256.Sum256(req.Body)
+
+	return strings.Join([]string{
+		"v2",
+		strings.ToUpper(req.Method),
+		req.Path,
+		canonicalQuery(req.Query),
+		hex.EncodeToString(bodyHash[:]),
+		strconv.FormatInt(timestamp, 10),
+	}, "\n")
+}
+
+// canonicalQuery sorts query parameters by key then value and re-encodes them
+func canonicalQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	for _, v := range values {
+		sort.Strings(v)
+	}
+
+	return values.Encode()
+}
+
+// computeSignatureV2 returns the base64 HMAC-SHA256 of the canonical string keyed by secret
+func computeSignatureV2(req SignatureRequest, timestamp int64, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(CanonicalSignatureString(req, timestamp)))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// parseSignatureV2 extracts the timestamp and signature from a v2 header value
+func parseSignatureV2(signature string) (int64, string, error) {
+	if !IsSignatureV2(signature) {
+		return 0, "", fmt.Errorf("%w: missing v2 prefix", ErrSignatureMalformed)
+	}
+
+	var timestamp int64
+	var sig string
+	for _, part := range strings.Split(strings.TrimPrefix(signature, SignatureV2Prefix), ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, "", fmt.Errorf("%w: cannot parse timestamp: %w", ErrSignatureMalformed, err)
+			}
+			timestamp = ts
+		case "sig":
+			sig = value
+		}
+	}
+
+	if timestamp == 0 || sig == "" {
+		return 0, "", fmt.Errorf("%w: missing fields", ErrSignatureMalformed)
+	}
+
+	return timestamp, sig, nil
+}
+
+// ValidateSignatureV2 validates a v2 signature of req keyed by the client secret
+func ValidateSignatureV2(signature string, req SignatureRequest, secret string) error {
+	return defaultSignatureValidator.ValidateV2(signature, req, secret)
+}
+
+// ValidateV2 validates a v2 signature of req keyed by the client secret
+func (v *SignatureValidator) ValidateV2(signature string, req SignatureRequest, secret string) error {
+	if secret == "" {
+		return ErrSignatureMissingSecret
+	}
+
+	timestamp, sig, err := parseSignatureV2(signature)
+	if err != nil {
+		return err
+	}
+
+	if err := v.checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	expected := computeSignatureV2(req, timestamp, secret)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrSignatureMismatch
 	}
 
 	return nil
@@ -0,0 +1,345 @@
+package middleware_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// clientSecret is the secret known server-side through the ClientResolver
+	clientSecret = "client-secret"
+	// tokenSecret is the client_secret claim of the bearer token, readable by anyone seeing the request
+	tokenSecret = "token-secret"
+)
+
+// legacySignature builds a v1 signature by hand, as integrators did before the signer existed
+func legacySignature(t *testing.T, token string, timestamp int64) string {
+	t.Helper()
+
+	h := hmac.New(sha256.New, []byte(token))
+	h.Write([]byte(fmt.Sprintf("%s%d", token, timestamp)))
+	payload, err := json.Marshal(middleware.SignaturePayload{
+		Token:     token,
+		Timestamp: timestamp,
+		Hash:      base64.StdEncoding.EncodeToString(h.Sum(nil)),
+	})
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(payload)
+}
+
+func v2Signature(req middleware.SignatureRequest, secret string, timestamp int64) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(middleware.CanonicalSignatureString(req, timestamp)))
+	return fmt.Sprintf("v2;t=%d;sig=%s", timestamp, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+func clientToken(t *testing.T) string {
+	t.Helper()
+
+	issuer, err := middleware.NewIssuer(middleware.IssuerConfig{Secret: "secret"})
+	require.NoError(t, err)
+	token, err := issuer.IssueClientToken(types.JWTClaimsSignature{ClientId: "client-1", ClientSecret: tokenSecret})
+	require.NoError(t, err)
+
+	return token
+}
+
+// signatureApp resolves "client-1" with clientSecret
+func signatureApp(opts ...middleware.AggregatorOption) *fiber.App {
+	resolver := middleware.NewMemoryClientResolver(types.IntegratorClient{ID: "client-1", Status: types.Active, Secret: clientSecret})
+
+	app := fiber.New()
+	app.Post("/wallet/debit",
+		middleware.NewAuthMiddlewareSignature("secret"),
+		middleware.ValidateAggregatorSignature(append([]middleware.AggregatorOption{middleware.WithClientResolver(resolver)}, opts...)...),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) },
+	)
+
+	return app
+}
+
+func TestValidateSignature(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   string
+	}{
+		{name: "success - valid signature", signature: legacySignature(t, "token", now)},
+		{name: "error - not base64", signature: "%%%", wantErr: "cannot decode base64"},
+		{name: "error - not JSON", signature: base64.StdEncoding.EncodeToString([]byte("nope")), wantErr: "cannot parse JSON"},
+		{name: "error - missing fields", signature: base64.StdEncoding.EncodeToString([]byte(`{"token":"token"}`)), wantErr: "missing fields"},
+		{name: "error - expired", signature: legacySignature(t, "token", now-10*60), wantErr: "signature expired"},
+		{name: "error - from future", signature: legacySignature(t, "token", now+10*60), wantErr: "timestamp from future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := middleware.ValidateSignature(tt.signature)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateSignatureV2(t *testing.T) {
+	now := time.Now().Unix()
+	req := middleware.SignatureRequest{Method: "POST", Path: "/wallet/debit", Query: "b=2&a=1", Body: []byte(`{"amount":100}`)}
+
+	tests := []struct {
+		name      string
+		signature string
+		req       middleware.SignatureRequest
+		secret    string
+		wantErr   string
+	}{
+		{name: "success - valid signature", signature: v2Signature(req, clientSecret, now), req: req, secret: clientSecret},
+		{
+			name:      "success - query order does not matter",
+			signature: v2Signature(req, clientSecret, now),
+			req:       middleware.SignatureRequest{Method: "POST", Path: "/wallet/debit", Query: "a=1&b=2", Body: req.Body},
+			secret:    clientSecret,
+		},
+		{
+			name:      "error - tampered body",
+			signature: v2Signature(req, clientSecret, now),
+			req:       middleware.SignatureRequest{Method: "POST", Path: "/wallet/debit", Query: req.Query, Body: []byte(`{"amount":1000}`)},
+			secret:    clientSecret,
+			wantErr:   "hash mismatch",
+		},
+		{
+			name:      "error - different path",
+			signature: v2Signature(req, clientSecret, now),
+			req:       middleware.SignatureRequest{Method: "POST", Path: "/wallet/credit", Query: req.Query, Body: req.Body},
+			secret:    clientSecret,
+			wantErr:   "hash mismatch",
+		},
+		{name: "error - wrong secret", signature: v2Signature(req, "other", now), req: req, secret: clientSecret, wantErr: "hash mismatch"},
+		{name: "error - missing secret", signature: v2Signature(req, clientSecret, now), req: req, wantErr: "missing client secret"},
+		{name: "error - expired", signature: v2Signature(req, clientSecret, now-10*60), req: req, secret: clientSecret, wantErr: "signature expired"},
+		{name: "error - missing fields", signature: "v2;t=1", req: req, secret: clientSecret, wantErr: "missing fields"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := middleware.ValidateSignatureV2(tt.signature, tt.req, tt.secret)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateAggregatorSignature(t *testing.T) {
+	token := clientToken(t)
+	body := `{"amount":100}`
+	now := time.Now().Unix()
+	signedReq := middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit", Query: "currency=IDR", Body: []byte(body)}
+
+	tests := []struct {
+		name       string
+		opts       []middleware.AggregatorOption
+		signature  string
+		wantStatus int
+		wantCode   int
+	}{
+		{
+			name:       "success - v1 signature during migration",
+			signature:  legacySignature(t, token, now),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "success - v2 signature",
+			signature:  v2Signature(signedReq, clientSecret, now),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "success - v2 signature with legacy disabled",
+			opts:       []middleware.AggregatorOption{middleware.WithLegacySignatures(false)},
+			signature:  v2Signature(signedReq, clientSecret, now),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "error - v1 signature with legacy disabled",
+			opts:       []middleware.AggregatorOption{middleware.WithLegacySignatures(false)},
+			signature:  legacySignature(t, token, now),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   common.ErrInvalidSignature.Code,
+		},
+		{
+			name:       "error - v2 signature with wrong secret",
+			signature:  v2Signature(signedReq, "other", now),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   common.ErrInvalidSignature.Code,
+		},
+		{
+			name:       "error - v2 signature keyed by the token secret",
+			signature:  v2Signature(signedReq, tokenSecret, now),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   common.ErrInvalidSignature.Code,
+		},
+		{
+			name:       "error - expired v2 signature",
+			signature:  v2Signature(signedReq, clientSecret, now-10*60),
+			wantStatus: http.StatusForbidden,
+			wantCode:   common.ErrSessionExpired.Code,
+		},
+		{
+			name:       "error - missing signature",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   common.ErrMissingAggregatorSignature.Code,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/wallet/debit?currency=IDR", strings.NewReader(body))
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			if tt.signature != "" {
+				req.Header.Set("X-Aggregator-Signature", tt.signature)
+			}
+			resp, err := signatureApp(tt.opts...).Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			}
+		})
+	}
+}
+
+func TestValidateAggregatorSignature_WithoutResolver(t *testing.T) {
+	token := clientToken(t)
+	now := time.Now().Unix()
+	app := fiber.New()
+	app.Post("/wallet/debit",
+		middleware.NewAuthMiddlewareSignature("secret"),
+		middleware.ValidateAggregatorSignature(),
+		func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) },
+	)
+
+	send := func(signature string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/wallet/debit", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set("X-Aggregator-Signature", signature)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Even signed with the secret carried by the token, v2 has no server-side secret to be checked against
+	resp := send(v2Signature(middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit"}, tokenSecret, now))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, common.ErrInvalidSignature.Code, responseCode(t, resp))
+
+	resp = send(legacySignature(t, token, now))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSignatureValidator(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	req := middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit"}
+
+	tests := []struct {
+		name      string
+		opts      []middleware.SignatureValidatorOption
+		timestamp int64
+		wantErr   error
+	}{
+		{name: "success - exactly max age", timestamp: now.Unix() - 300},
+		{name: "error - one second past max age", timestamp: now.Unix() - 301, wantErr: middleware.ErrSignatureExpired},
+		{name: "success - exactly allowed skew", timestamp: now.Unix() + 60},
+		{name: "error - one second past allowed skew", timestamp: now.Unix() + 61, wantErr: middleware.ErrSignatureFromFuture},
+		{
+			name:      "success - custom max age",
+			opts:      []middleware.SignatureValidatorOption{middleware.WithSignatureMaxAge(time.Hour)},
+			timestamp: now.Unix() - 1800,
+		},
+		{
+			name:      "error - custom skew",
+			opts:      []middleware.SignatureValidatorOption{middleware.WithSignatureSkew(0)},
+			timestamp: now.Unix() + 1,
+			wantErr:   middleware.ErrSignatureFromFuture,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := middleware.NewSignatureValidator(append(tt.opts, middleware.WithSignatureClock(clock))...)
+
+			errV1 := v.Validate(legacySignature(t, "token", tt.timestamp))
+			errV2 := v.ValidateV2(v2Signature(req, clientSecret, tt.timestamp), req, clientSecret)
+			if tt.wantErr == nil {
+				assert.NoError(t, errV1)
+				assert.NoError(t, errV2)
+				return
+			}
+			assert.ErrorIs(t, errV1, tt.wantErr)
+			assert.ErrorIs(t, errV2, tt.wantErr)
+		})
+	}
+}
+
+func TestSignatureValidator_Errors(t *testing.T) {
+	v := middleware.NewSignatureValidator()
+	now := time.Now().Unix()
+	req := middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit"}
+
+	assert.ErrorIs(t, v.Validate("%%%"), middleware.ErrSignatureMalformed)
+	assert.ErrorIs(t, v.ValidateV2("v2;t=abc;sig=x", req, clientSecret), middleware.ErrSignatureMalformed)
+	assert.ErrorIs(t, v.ValidateV2(v2Signature(req, "other", now), req, clientSecret), middleware.ErrSignatureMismatch)
+	assert.ErrorIs(t, v.ValidateV2(v2Signature(req, clientSecret, now), req, ""), middleware.ErrSignatureMissingSecret)
+}
+
+func TestValidateAggregatorSignature_Validator(t *testing.T) {
+	token := clientToken(t)
+	now := time.Now()
+	validator := middleware.NewSignatureValidator(
+		middleware.WithSignatureHeader("X-Signature"),
+		middleware.WithSignatureMaxAge(time.Minute),
+		middleware.WithSignatureClock(func() time.Time { return now }),
+	)
+	app := signatureApp(middleware.WithSignatureValidator(validator))
+
+	send := func(header, signature string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/wallet/debit", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set(header, signature)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send("X-Signature", legacySignature(t, token, now.Unix()))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = send("X-Aggregator-Signature", legacySignature(t, token, now.Unix()))
+	assert.Equal(t, common.ErrMissingAggregatorSignature.Code, responseCode(t, resp))
+
+	resp = send("X-Signature", legacySignature(t, token, now.Add(-2*time.Minute).Unix()))
+	assert.Equal(t, common.ErrSessionExpired.Code, responseCode(t, resp))
+}
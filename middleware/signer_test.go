@@ -0,0 +1,122 @@
+package middleware_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestSigner_RoundTrip(t *testing.T) {
+	req := middleware.SignatureRequest{Method: http.MethodPost, Path: "/wallet/debit", Query: "currency=IDR", Body: []byte(`{"amount":100}`)}
+
+	t.Run("legacy signature is accepted by ValidateSignature", func(t *testing.T) {
+		signature, err := middleware.NewSigner("access-token", "").Sign(req)
+		require.NoError(t, err)
+
+		assert.False(t, middleware.IsSignatureV2(signature))
+		assert.NoError(t, middleware.ValidateSignature(signature))
+	})
+
+	t.Run("v2 signature is accepted by ValidateSignatureV2", func(t *testing.T) {
+		signature, err := middleware.NewSigner("access-token", clientSecret).Sign(req)
+		require.NoError(t, err)
+
+		assert.True(t, middleware.IsSignatureV2(signature))
+		assert.NoError(t, middleware.ValidateSignatureV2(signature, req, clientSecret))
+		assert.Error(t, middleware.ValidateSignatureV2(signature, req, "other"))
+	})
+
+	t.Run("signer matches the hand-built protocol", func(t *testing.T) {
+		signature, err := middleware.NewSigner("access-token", clientSecret).Sign(req)
+		require.NoError(t, err)
+		timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ";")[1], "t="), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, v2Signature(req, clientSecret, timestamp), signature)
+
+		signature, err = middleware.NewSigner("access-token", "").Sign(req)
+		require.NoError(t, err)
+		raw, err := base64.StdEncoding.DecodeString(signature)
+		require.NoError(t, err)
+		var payload middleware.SignaturePayload
+		require.NoError(t, json.Unmarshal(raw, &payload))
+		assert.Equal(t, legacySignature(t, "access-token", payload.Timestamp), signature)
+	})
+}
+
+func TestSigner_SignHTTPRequest(t *testing.T) {
+	token := clientToken(t)
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{name: "legacy", secret: ""},
+		{name: "v2", secret: clientSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/wallet/debit?currency=IDR&amount=100", strings.NewReader(`{"amount":100}`))
+			require.NoError(t, middleware.NewSigner(token, tt.secret).SignHTTPRequest(req))
+
+			resp, err := signatureApp().Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestSigner_SignFastHTTPRequest(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	app := signatureApp()
+	go func() { _ = app.Listener(ln) }()
+	defer func() { _ = app.Shutdown() }()
+
+	token := clientToken(t)
+	signer := middleware.NewSigner(token, clientSecret)
+
+	tests := []struct {
+		name       string
+		body       string
+		tamper     bool
+		wantStatus int
+	}{
+		{name: "success - signed request", body: `{"amount":100}`, wantStatus: http.StatusOK},
+		{name: "error - body changed after signing", body: `{"amount":100}`, tamper: true, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			req.SetRequestURI("http://" + ln.Addr().String() + "/wallet/debit?currency=IDR")
+			req.Header.SetMethod(http.MethodPost)
+			req.SetBodyString(tt.body)
+			require.NoError(t, signer.SignFastHTTPRequest(req))
+			assert.Equal(t, "Bearer "+token, string(req.Header.Peek(fiber.HeaderAuthorization)))
+
+			if tt.tamper {
+				req.SetBodyString(`{"amount":100000}`)
+			}
+
+			require.NoError(t, fasthttp.DoTimeout(req, resp, 5*time.Second))
+			assert.Equal(t, tt.wantStatus, resp.StatusCode())
+		})
+	}
+}
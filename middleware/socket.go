@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+)
+
+var (
+	ErrSocketHandshakeMalformed = errors.New("socket handshake malformed")
+	ErrSocketTokenMissing       = errors.New("socket handshake token missing")
+	ErrSocketUnauthorized       = errors.New("socket connection unauthorized")
+)
+
+// SocketHandshake is the auth payload sent by websocket and socket.io clients when connecting
+type SocketHandshake struct {
+	Token string `json:"token"`
+}
+
+// ParseSocketHandshake : Read the handshake from a socket.io auth object (map[string]any or map[string]string),
+// a JSON payload or a SocketHandshake. Unsupported payloads return ErrSocketHandshakeMalformed.
+func ParseSocketHandshake(auth any) (SocketHandshake, error) {
+	switch v := auth.(type) {
+	case SocketHandshake:
+		return v, nil
+	case *SocketHandshake:
+		if v != nil {
+			return *v, nil
+		}
+	case map[string]string:
+		return SocketHandshake{Token: v["token"]}, nil
+	case map[string]any:
+		if v["token"] == nil {
+			return SocketHandshake{}, nil
+		}
+		if token, ok := v["token"].(string); ok {
+			return SocketHandshake{Token: token}, nil
+		}
+	case []byte:
+		return parseSocketHandshakeJSON(v)
+	case json.RawMessage:
+		return parseSocketHandshakeJSON(v)
+	}
+
+	return SocketHandshake{}, ErrSocketHandshakeMalformed
+}
+
+func parseSocketHandshakeJSON(payload []byte) (SocketHandshake, error) {
+	var handshake SocketHandshake
+	if err := json.Unmarshal(payload, &handshake); err != nil {
+		return SocketHandshake{}, fmt.Errorf("%w: %w", ErrSocketHandshakeMalformed, err)
+	}
+
+	return handshake, nil
+}
+
+// WithStaticSecrets : Accept any of the given static secrets as socket token, see NewSocketAuthenticator.
+// Several secrets can be configured while rotating, empty secrets are ignored.
+// NewAuthMiddleware and NewAuthMiddlewareSignature panic when given this option.
+func WithStaticSecrets(secrets ...string) AuthOption {
+	return func(cfg *authConfig) {
+		for _, secret := range secrets {
+			if secret != "" {
+				cfg.staticSecrets = append(cfg.staticSecrets, secret)
+			}
+		}
+	}
+}
+
+// SocketAuthenticator authenticates websocket and socket.io connections from their handshake token
+type SocketAuthenticator struct {
+	verifier      *tokenVerifier
+	staticSecrets [][sha256.Size]byte
+}
+
+// NewSocketAuthenticator : Initialize new socket authenticator.
+// JWTs are verified with the same options as NewAuthMiddleware and resolve to their *types.JWTClaims.
+// Tokens matching one of WithStaticSecrets resolve to a *types.ServicePrincipal whose ID is
+// "static-secret-<index>", so connections still using a rotated-out secret can be told apart.
+// JWT verification is disabled when secret is empty and no public key or JWKS is configured.
+func NewSocketAuthenticator(secret string, opts ...AuthOption) (*SocketAuthenticator, error) {
+	cfg := newAuthConfig(secret, opts)
+
+	a := &SocketAuthenticator{}
+	for _, static := range cfg.staticSecrets {
+		a.staticSecrets = append(a.staticSecrets, sha256.Sum256([]byte(static)))
+	}
+
+	if cfg.secret != "" || len(cfg.publicKeys) > 0 || cfg.jwksURL != "" {
+		verifier, err := newTokenVerifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		a.verifier = verifier
+	}
+
+	if a.verifier == nil && len(a.staticSecrets) == 0 {
+		return nil, errors.New("at least one of secret, public key, JWKS URL or static secret is required")
+	}
+
+	return a, nil
+}
+
+// Authenticate : Verify the handshake token and return the principal of the connection.
+// Failures wrap ErrSocketTokenMissing or ErrSocketUnauthorized.
+func (a *SocketAuthenticator) Authenticate(ctx context.Context, handshake SocketHandshake) (types.Principal, error) {
+	if handshake.Token == "" {
+		return nil, ErrSocketTokenMissing
+	}
+
+	if i := matchStaticSecret(handshake.Token, a.staticSecrets); i >= 0 {
+		return &types.ServicePrincipal{ID: fmt.Sprintf("static-secret-%d", i)}, nil
+	}
+
+	if a.verifier == nil {
+		return nil, ErrSocketUnauthorized
+	}
+
+	claims := &types.JWTClaims{}
+	if _, err := a.verifier.verify(ctx, handshake.Token, claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSocketUnauthorized, err)
+	}
+
+	return claims, nil
+}
+
+// AuthenticatePayload : Parse the raw socket.io auth object with ParseSocketHandshake, then Authenticate it
+func (a *SocketAuthenticator) AuthenticatePayload(ctx context.Context, auth any) (types.Principal, error) {
+	handshake, err := ParseSocketHandshake(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Authenticate(ctx, handshake)
+}
+
+// matchStaticSecret returns the index of the secret matching token, or -1.
+// Every secret is compared in constant time so the timing leaks neither the match nor its position.
+func matchStaticSecret(token string, secrets [][sha256.Size]byte) int {
+	hashed := sha256.Sum256([]byte(token))
+	match := -1
+	for i := range secrets {
+		equal := subtle.ConstantTimeCompare(hashed[:], secrets[i][:])
+		match = subtle.ConstantTimeSelect(equal, i, match)
+	}
+
+	return match
+}
+
+// ValidateSocketToken : Check the socket.io auth "token" against the STATIC_SECRET environment variable.
+//
+// Deprecated: Use NewSocketAuthenticator with WithStaticSecrets, which also verifies JWTs,
+// supports rotated secrets and returns the connection principal.
+func ValidateSocketToken(auth any) error {
+	handshake, err := ParseSocketHandshake(auth)
+	if err != nil || handshake.Token == "" {
+		return ErrSocketUnauthorized
+	}
+
+	secret := os.Getenv("STATIC_SECRET")
+	if secret == "" || matchStaticSecret(handshake.Token, [][sha256.Size]byte{sha256.Sum256([]byte(secret))}) < 0 {
+		return ErrSocketUnauthorized
+	}
+
+	return nil
+}
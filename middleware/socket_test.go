@@ -0,0 +1,124 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSocketHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      any
+		wantToken string
+		wantErr   error
+	}{
+		{name: "success - socket.io auth object", auth: map[string]any{"token": "abc"}, wantToken: "abc"},
+		{name: "success - string map", auth: map[string]string{"token": "abc"}, wantToken: "abc"},
+		{name: "success - JSON payload", auth: []byte(`{"token":"abc"}`), wantToken: "abc"},
+		{name: "success - typed handshake", auth: &middleware.SocketHandshake{Token: "abc"}, wantToken: "abc"},
+		{name: "success - missing token", auth: map[string]any{}},
+		{name: "error - token is not a string", auth: map[string]any{"token": 42}, wantErr: middleware.ErrSocketHandshakeMalformed},
+		{name: "error - not a map", auth: []string{"abc"}, wantErr: middleware.ErrSocketHandshakeMalformed},
+		{name: "error - nil", auth: nil, wantErr: middleware.ErrSocketHandshakeMalformed},
+		{name: "error - invalid JSON", auth: json.RawMessage(`{`), wantErr: middleware.ErrSocketHandshakeMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handshake, err := middleware.ParseSocketHandshake(tt.auth)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantToken, handshake.Token)
+		})
+	}
+}
+
+func TestSocketAuthenticator(t *testing.T) {
+	authenticator, err := middleware.NewSocketAuthenticator("secret",
+		middleware.WithIssuer("agg"),
+		middleware.WithStaticSecrets("current-secret", "", "previous-secret"),
+	)
+	require.NoError(t, err)
+
+	validJWT := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) { c.Issuer = "agg" }))
+
+	tests := []struct {
+		name     string
+		auth     any
+		wantID   string
+		wantKind types.PrincipalKind
+		wantErr  error
+	}{
+		{name: "success - JWT", auth: map[string]any{"token": validJWT}, wantID: "user-1", wantKind: types.PrincipalKindUser},
+		{name: "success - current static secret", auth: map[string]any{"token": "current-secret"}, wantID: "static-secret-0", wantKind: types.PrincipalKindService},
+		{name: "success - rotated static secret", auth: map[string]any{"token": "previous-secret"}, wantID: "static-secret-1", wantKind: types.PrincipalKindService},
+		{name: "error - unknown secret", auth: map[string]any{"token": "nope"}, wantErr: middleware.ErrSocketUnauthorized},
+		{
+			name: "error - JWT from another issuer",
+			auth: map[string]any{"token": signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+				c.Issuer = "other"
+			}))},
+			wantErr: middleware.ErrSocketUnauthorized,
+		},
+		{
+			name: "error - expired JWT",
+			auth: map[string]any{"token": signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+				c.Issuer = "agg"
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			}))},
+			wantErr: jwt.ErrTokenExpired,
+		},
+		{name: "error - missing token", auth: map[string]any{}, wantErr: middleware.ErrSocketTokenMissing},
+		{name: "error - malformed payload", auth: "token", wantErr: middleware.ErrSocketHandshakeMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authenticator.AuthenticatePayload(context.Background(), tt.auth)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Nil(t, principal)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, principal.PrincipalID())
+			assert.Equal(t, tt.wantKind, principal.PrincipalKind())
+		})
+	}
+}
+
+func TestNewSocketAuthenticator_Config(t *testing.T) {
+	_, err := middleware.NewSocketAuthenticator("")
+	assert.Error(t, err)
+
+	// Static secrets alone disable JWT verification
+	authenticator, err := middleware.NewSocketAuthenticator("", middleware.WithStaticSecrets("static"))
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(context.Background(), middleware.SocketHandshake{
+		Token: signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims()),
+	})
+	assert.ErrorIs(t, err, middleware.ErrSocketUnauthorized)
+}
+
+func TestValidateSocketToken(t *testing.T) {
+	t.Setenv("STATIC_SECRET", "static")
+
+	assert.NoError(t, middleware.ValidateSocketToken(map[string]any{"token": "static"}))
+	assert.Error(t, middleware.ValidateSocketToken(map[string]any{"token": "other"}))
+	assert.Error(t, middleware.ValidateSocketToken(map[string]any{"token": 1}))
+	assert.NotPanics(t, func() { assert.Error(t, middleware.ValidateSocketToken("static")) })
+
+	t.Setenv("STATIC_SECRET", "")
+	assert.Error(t, middleware.ValidateSocketToken(map[string]any{"token": ""}))
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/types"
+)
+
+// evaluateStepUp evaluates req with evaluator and turns a grant into a denial when claims
+// did not authenticate recently enough for the permission, see checkStepUp
+func evaluateStepUp(ctx context.Context, evaluator PolicyEvaluator, claims *types.JWTClaims, req AuthzRequest) (Decision, error) {
+	decision, err := evaluator.Evaluate(ctx, req)
+	if err != nil || !decision.Allowed {
+		return decision, err
+	}
+	if stepUp, required := checkStepUp(claims, req.Resource, req.Action); required {
+		return stepUp, nil
+	}
+
+	return decision, nil
+}
+
+// denialError returns the error responded for a denial
+func denialError(decision Decision) common.Error {
+	if decision.stepUp {
+		return common.ErrStepUpRequired
+	}
+
+	return common.ErrForbidden
+}
+
+// checkStepUp returns a denial when the permission requires a more recent authentication for action,
+// see types.SetPermissionStepUp. The caller then responds with common.ErrStepUpRequired.
+func checkStepUp(claims *types.JWTClaims, permission types.Permission, action types.PermissionAction) (Decision, bool) {
+	def, ok := types.LookupPermission(string(permission))
+	if !ok || def.StepUp == nil || !def.StepUp.AppliesTo(action) {
+		return Decision{}, false
+	}
+	if def.StepUp.SatisfiedBy(claims, time.Now()) {
+		return Decision{}, false
+	}
+
+	reason := fmt.Sprintf("%s %v requires authentication within %s", def.Key, action.Names(), def.StepUp.MaxAge)
+	if len(def.StepUp.Methods) > 0 {
+		reason += fmt.Sprintf(" with %v", def.StepUp.Methods)
+	}
+
+	return Decision{Reason: reason, stepUp: true}, true
+}
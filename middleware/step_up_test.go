@@ -0,0 +1,90 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	types.SetPermissionStepUp(types.PermissionRegenerateSecret, types.StepUpRequirement{
+		MaxAge:  5 * time.Minute,
+		Actions: types.ActionWrite | types.ActionDelete,
+		Methods: []string{types.AMRPassword, types.AMROTP},
+	})
+}
+
+func TestStepUp(t *testing.T) {
+	token := func(authAge time.Duration, amr ...string) string {
+		return signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+			c.Type = types.RoleUser
+			c.Permissions = types.PermissionsDTO{types.PermissionRegenerateSecret: types.ActionAll}
+			if authAge > 0 {
+				c.AuthTime = jwt.NewNumericDate(time.Now().Add(-authAge))
+			}
+			c.AMR = amr
+		}))
+	}
+	recent := token(time.Minute, types.AMROTP)
+	stale := token(time.Hour, types.AMRPassword)
+	unknownMethod := token(time.Minute, "kba")
+	noAuthTime := token(0)
+
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	auth := middleware.NewAuthMiddleware("secret")
+	app := fiber.New()
+	app.All("/secret", auth, middleware.ValidatePermission(types.PermissionRegenerateSecret), ok)
+	app.Post("/secret/requirement", auth, middleware.RequireAny(
+		middleware.RequireRole(types.RoleAdmin),
+		middleware.RequireAction(types.PermissionRegenerateSecret, types.ActionWrite),
+	).Handler(), ok)
+	app.Post("/secret/not", auth, middleware.Not(middleware.RequirePermission(types.PermissionRegenerateSecret)).Handler(), ok)
+	policies := app.Group("/policy", auth, middleware.EnforcePolicy(middleware.NewPolicyTable(
+		middleware.RoutePolicy{Path: "/policy/secret", Permission: types.PermissionRegenerateSecret},
+	)))
+	policies.All("/secret", ok)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		token      string
+		wantStatus int
+		wantCode   int
+	}{
+		{name: "success - recent authentication", method: http.MethodPost, target: "/secret", token: recent, wantStatus: http.StatusOK},
+		{name: "success - action without step-up", method: http.MethodGet, target: "/secret", token: stale, wantStatus: http.StatusOK},
+		{name: "error - stale authentication", method: http.MethodPost, target: "/secret", token: stale, wantStatus: http.StatusUnauthorized, wantCode: common.ErrStepUpRequired.Code},
+		{name: "error - method not accepted", method: http.MethodDelete, target: "/secret", token: unknownMethod, wantStatus: http.StatusUnauthorized, wantCode: common.ErrStepUpRequired.Code},
+		{name: "error - no auth_time", method: http.MethodPut, target: "/secret", token: noAuthTime, wantStatus: http.StatusUnauthorized, wantCode: common.ErrStepUpRequired.Code},
+		{name: "success - requirement with recent authentication", method: http.MethodPost, target: "/secret/requirement", token: recent, wantStatus: http.StatusOK},
+		{name: "error - requirement with stale authentication", method: http.MethodPost, target: "/secret/requirement", token: stale, wantStatus: http.StatusUnauthorized, wantCode: common.ErrStepUpRequired.Code},
+		{name: "error - negated step-up denial is kept", method: http.MethodPost, target: "/secret/not", token: stale, wantStatus: http.StatusUnauthorized, wantCode: common.ErrStepUpRequired.Code},
+		{name: "error - negated permission with recent authentication", method: http.MethodPost, target: "/secret/not", token: recent, wantStatus: http.StatusForbidden, wantCode: common.ErrForbidden.Code},
+		{name: "success - policy with recent authentication", method: http.MethodDelete, target: "/policy/secret", token: recent, wantStatus: http.StatusOK},
+		{name: "error - policy with stale authentication", method: http.MethodDelete, target: "/policy/secret", token: stale, wantStatus: http.StatusUnauthorized, wantCode: common.ErrStepUpRequired.Code},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, responseCode(t, resp))
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// NamespaceHeader is the default header holding the target namespace
+	NamespaceHeader = "X-Namespace"
+
+	defaultNamespaceLookup = "param:namespace,query:namespace,header:" + NamespaceHeader
+
+	// maxNamespaceDepth bounds the hierarchy walk, protecting against cycles in the resolver data
+	maxNamespaceDepth = 32
+)
+
+var (
+	// ErrNamespaceNotFound is returned by a NamespaceHierarchy for unknown namespaces
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	// ErrNamespaceOutOfScope is returned by CheckNamespaceScope when the target is outside the caller subtree
+	ErrNamespaceOutOfScope = errors.New("namespace out of scope")
+)
+
+// NamespaceHierarchy resolves the parent of a namespace, "" for a root namespace
+type NamespaceHierarchy interface {
+	ParentNamespace(ctx context.Context, namespace string) (string, error)
+}
+
+// MemoryNamespaceHierarchy is an in-memory NamespaceHierarchy keyed by child namespace, suitable for tests
+type MemoryNamespaceHierarchy struct {
+	mu      sync.RWMutex
+	parents map[string]string
+}
+
+// NewMemoryNamespaceHierarchy : Initialize new in-memory hierarchy from a child to parent namespace map
+func NewMemoryNamespaceHierarchy(parents map[string]string) *MemoryNamespaceHierarchy {
+	h := &MemoryNamespaceHierarchy{parents: make(map[string]string, len(parents))}
+	for child, parent := range parents {
+		h.parents[child] = parent
+	}
+
+	return h
+}
+
+// Set adds or moves a namespace under parent
+func (h *MemoryNamespaceHierarchy) Set(namespace, parent string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.parents[namespace] = parent
+}
+
+// ParentNamespace implements NamespaceHierarchy
+func (h *MemoryNamespaceHierarchy) ParentNamespace(_ context.Context, namespace string) (string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	parent, ok := h.parents[namespace]
+	if !ok {
+		return "", ErrNamespaceNotFound
+	}
+
+	return parent, nil
+}
+
+// CheckNamespaceScope : Check that target equals or descends from the caller namespace.
+// It returns ErrNamespaceOutOfScope when it does not, or the hierarchy error when the walk fails.
+// Without a hierarchy only the caller namespace itself is in scope.
+func CheckNamespaceScope(ctx context.Context, hierarchy NamespaceHierarchy, caller, target string) error {
+	if caller == "" || target == "" {
+		return ErrNamespaceOutOfScope
+	}
+	if target == caller {
+		return nil
+	}
+	if hierarchy == nil {
+		return ErrNamespaceOutOfScope
+	}
+
+	namespace := target
+	for i := 0; i < maxNamespaceDepth; i++ {
+		parent, err := hierarchy.ParentNamespace(ctx, namespace)
+		if errors.Is(err, ErrNamespaceNotFound) {
+			return ErrNamespaceOutOfScope
+		}
+		if err != nil {
+			return fmt.Errorf("cannot resolve parent of namespace %s: %w", namespace, err)
+		}
+
+		switch parent {
+		case caller:
+			return nil
+		case "":
+			return ErrNamespaceOutOfScope
+		}
+		namespace = parent
+	}
+
+	return ErrNamespaceOutOfScope
+}
+
+// TenantOption configures NewTenantScopeMiddleware
+type TenantOption func(*tenantConfig)
+
+type tenantConfig struct {
+	lookup        string
+	hierarchy     NamespaceHierarchy
+	unscopedRoles map[types.Role]bool
+}
+
+// WithNamespaceLookup : Read the target namespace from the given sources instead of the "namespace"
+// path param, "namespace" query or X-Namespace header, in the same format as WithTokenLookup
+func WithNamespaceLookup(lookup string) TenantOption {
+	return func(cfg *tenantConfig) {
+		cfg.lookup = lookup
+	}
+}
+
+// WithNamespaceHierarchy : Allow targets descending from the caller namespace, resolved with hierarchy
+func WithNamespaceHierarchy(hierarchy NamespaceHierarchy) TenantOption {
+	return func(cfg *tenantConfig) {
+		cfg.hierarchy = hierarchy
+	}
+}
+
+// WithUnscopedRoles : Let callers with one of the given roles, e.g. types.RoleAdmin, target any namespace
+func WithUnscopedRoles(roles ...types.Role) TenantOption {
+	return func(cfg *tenantConfig) {
+		for _, role := range roles {
+			cfg.unscopedRoles[role] = true
+		}
+	}
+}
+
+// NewTenantScopeMiddleware : Initialize new instance for tenant scope middleware.
+// The target namespace defaults to the caller namespace when the request names none.
+// The effective tenant is stored in Locals "tenant", see contek.GetTenant, for repositories to filter on.
+func NewTenantScopeMiddleware(opts ...TenantOption) fiber.Handler {
+	cfg := tenantConfig{lookup: defaultNamespaceLookup, unscopedRoles: make(map[types.Role]bool)}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	extractors, err := tokenExtractors(cfg.lookup)
+	if err != nil {
+		panic("tenant scope middleware configuration: " + err.Error())
+	}
+
+	return func(c *fiber.Ctx) error {
+		principal := contek.GetPrincipal(c.Context())
+		if principal == nil {
+			slog.Warn("Tenant scope: principal not found in Locals")
+			return common.Response().SetError(common.ErrUnauthorized).Send(c)
+		}
+
+		caller := principal.PrincipalNamespace()
+		target, _ := extractToken(c, extractors)
+		if target == "" {
+			target = caller
+		}
+
+		if target == "" || !cfg.unscopedRoles[principal.PrincipalRole()] {
+			err := CheckNamespaceScope(c.Context(), cfg.hierarchy, caller, target)
+			if errors.Is(err, ErrNamespaceOutOfScope) {
+				slog.Info("Tenant scope denied", "principal_id", principal.PrincipalID(), "namespace", caller, "target", target)
+				return common.Response().SetError(common.ErrForbidden).Send(c)
+			}
+			if err != nil {
+				slog.Warn("Tenant scope check failed", "principal_id", principal.PrincipalID(), "target", target, "error", err.Error())
+				return common.Response().SetError(common.ErrServerError).Send(c)
+			}
+		}
+
+		c.Locals("tenant", target)
+		return c.Next()
+	}
+}
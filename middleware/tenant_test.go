@@ -0,0 +1,182 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/SoeltanIT/agg-common-be"
+	"github.com/SoeltanIT/agg-common-be/contek"
+	"github.com/SoeltanIT/agg-common-be/middleware"
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingHierarchy struct{}
+
+func (failingHierarchy) ParentNamespace(context.Context, string) (string, error) {
+	return "", errors.New("database unavailable")
+}
+
+func TestCheckNamespaceScope(t *testing.T) {
+	hierarchy := middleware.NewMemoryNamespaceHierarchy(map[string]string{
+		"super-1": "",
+		"agent-1": "super-1",
+		"sub-1":   "agent-1",
+		"super-2": "",
+		"agent-2": "super-2",
+		"loop-a":  "loop-b",
+		"loop-b":  "loop-a",
+	})
+
+	tests := []struct {
+		name      string
+		hierarchy middleware.NamespaceHierarchy
+		caller    string
+		target    string
+		wantErr   error
+	}{
+		{name: "success - own namespace", hierarchy: hierarchy, caller: "agent-1", target: "agent-1"},
+		{name: "success - child", hierarchy: hierarchy, caller: "super-1", target: "agent-1"},
+		{name: "success - grandchild", hierarchy: hierarchy, caller: "super-1", target: "sub-1"},
+		{name: "error - parent", hierarchy: hierarchy, caller: "agent-1", target: "super-1", wantErr: middleware.ErrNamespaceOutOfScope},
+		{name: "error - sibling subtree", hierarchy: hierarchy, caller: "super-1", target: "agent-2", wantErr: middleware.ErrNamespaceOutOfScope},
+		{name: "error - unknown target", hierarchy: hierarchy, caller: "super-1", target: "unknown", wantErr: middleware.ErrNamespaceOutOfScope},
+		{name: "error - cycle", hierarchy: hierarchy, caller: "super-1", target: "loop-a", wantErr: middleware.ErrNamespaceOutOfScope},
+		{name: "error - caller without namespace", hierarchy: hierarchy, caller: "", target: "agent-1", wantErr: middleware.ErrNamespaceOutOfScope},
+		{name: "error - child without hierarchy", caller: "super-1", target: "agent-1", wantErr: middleware.ErrNamespaceOutOfScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := middleware.CheckNamespaceScope(context.Background(), tt.hierarchy, tt.caller, tt.target)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	err := middleware.CheckNamespaceScope(context.Background(), failingHierarchy{}, "super-1", "agent-1")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, middleware.ErrNamespaceOutOfScope)
+}
+
+func TestNewTenantScopeMiddleware(t *testing.T) {
+	hierarchy := middleware.NewMemoryNamespaceHierarchy(map[string]string{
+		"super-1": "",
+		"agent-1": "super-1",
+		"agent-2": "super-2",
+	})
+
+	newApp := func(opts ...middleware.TenantOption) *fiber.App {
+		app := fiber.New()
+		echo := func(c *fiber.Ctx) error { return c.SendString(contek.GetTenant(c.Context())) }
+		auth := middleware.NewAuthMiddleware("secret")
+		app.Get("/players", auth, middleware.NewTenantScopeMiddleware(opts...), echo)
+		app.Get("/namespaces/:namespace/players", auth, middleware.NewTenantScopeMiddleware(opts...), echo)
+		return app
+	}
+	scoped := []middleware.TenantOption{middleware.WithNamespaceHierarchy(hierarchy)}
+
+	tests := []struct {
+		name          string
+		opts          []middleware.TenantOption
+		namespace     string
+		role          types.Role
+		target        string
+		header        string
+		wantStatus    int
+		wantTenant    string
+		wantErrorCode int
+	}{
+		{name: "success - defaults to caller namespace", opts: scoped, namespace: "super-1", target: "/players", wantStatus: http.StatusOK, wantTenant: "super-1"},
+		{name: "success - path param", opts: scoped, namespace: "super-1", target: "/namespaces/agent-1/players", wantStatus: http.StatusOK, wantTenant: "agent-1"},
+		{name: "success - query", opts: scoped, namespace: "super-1", target: "/players?namespace=agent-1", wantStatus: http.StatusOK, wantTenant: "agent-1"},
+		{name: "success - header", opts: scoped, namespace: "super-1", target: "/players", header: "agent-1", wantStatus: http.StatusOK, wantTenant: "agent-1"},
+		{
+			name:          "error - other subtree",
+			opts:          scoped,
+			namespace:     "super-1",
+			target:        "/namespaces/agent-2/players",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrForbidden.Code,
+		},
+		{
+			name:          "error - agent targets its parent",
+			opts:          scoped,
+			namespace:     "agent-1",
+			target:        "/players?namespace=super-1",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrForbidden.Code,
+		},
+		{
+			name:          "error - caller without namespace",
+			opts:          scoped,
+			target:        "/players",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: common.ErrForbidden.Code,
+		},
+		{
+			name:       "success - unscoped role",
+			opts:       append(scoped, middleware.WithUnscopedRoles(types.RoleAdmin)),
+			role:       types.RoleAdmin,
+			target:     "/namespaces/agent-2/players",
+			wantStatus: http.StatusOK,
+			wantTenant: "agent-2",
+		},
+		{
+			name:       "success - custom lookup ignores the default sources",
+			opts:       append(scoped, middleware.WithNamespaceLookup("header:X-Tenant")),
+			namespace:  "agent-1",
+			target:     "/players",
+			header:     "agent-2",
+			wantStatus: http.StatusOK,
+			wantTenant: "agent-1",
+		},
+		{
+			name:          "error - hierarchy failure",
+			opts:          []middleware.TenantOption{middleware.WithNamespaceHierarchy(failingHierarchy{})},
+			namespace:     "super-1",
+			target:        "/players?namespace=agent-1",
+			wantStatus:    http.StatusInternalServerError,
+			wantErrorCode: common.ErrServerError.Code,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims(func(c *types.JWTClaims) {
+				c.Namespace = tt.namespace
+				if tt.role != "" {
+					c.Type = tt.role
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.RequestURI = tt.target
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			if tt.header != "" {
+				req.Header.Set(middleware.NamespaceHeader, tt.header)
+			}
+			resp, err := newApp(tt.opts...).Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantErrorCode != 0 {
+				assert.Equal(t, tt.wantErrorCode, responseCode(t, resp))
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTenant, string(body))
+		})
+	}
+}
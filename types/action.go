@@ -0,0 +1,105 @@
+package types
+
+import (
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type PermissionAction int
+
+const (
+	ActionRead   PermissionAction = 1 << iota // 1
+	ActionWrite                               // 2
+	ActionDelete                              // 4
+
+	ActionAll = ActionRead | ActionWrite | ActionDelete
+)
+
+type actionName struct {
+	action PermissionAction
+	name   string
+}
+
+var actionRegistry = struct {
+	mu    sync.RWMutex
+	names []actionName
+}{names: []actionName{
+	{ActionRead, "read"},
+	{ActionWrite, "write"},
+	{ActionDelete, "delete"},
+}}
+
+// RegisterAction : Declare an action beyond read, write and delete, e.g. "approve" or "export", and get its bit.
+// Permissions grant it once declared with ExtendPermission. It panics when the name is empty or already registered,
+// actions are expected to be registered at init time.
+func RegisterAction(name string) PermissionAction {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		panic("types: action name is required")
+	}
+
+	actionRegistry.mu.Lock()
+	defer actionRegistry.mu.Unlock()
+
+	var used PermissionAction
+	for _, n := range actionRegistry.names {
+		if n.name == name {
+			panic(fmt.Sprintf("types: action %q registered twice", name))
+		}
+		used |= n.action
+	}
+	// PermissionAction must stay positive to be serialised as before
+	if bits.Len(uint(used)) >= bits.UintSize-1 {
+		panic("types: no action bit left for " + name)
+	}
+
+	action := PermissionAction(1) << bits.Len(uint(used))
+	actionRegistry.names = append(actionRegistry.names, actionName{action, name})
+
+	return action
+}
+
+// Names returns the names of the actions set in a, e.g. ["read","write"]
+func (a PermissionAction) Names() []string {
+	actionRegistry.mu.RLock()
+	defer actionRegistry.mu.RUnlock()
+
+	names := []string{}
+	for _, n := range actionRegistry.names {
+		if a&n.action != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	return names
+}
+
+// ParsePermissionAction : Parse an action name such as "read", names are matched case-insensitively
+func ParsePermissionAction(name string) (PermissionAction, bool) {
+	actionRegistry.mu.RLock()
+	defer actionRegistry.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, n := range actionRegistry.names {
+		if n.name == name {
+			return n.action, true
+		}
+	}
+
+	return 0, false
+}
+
+// MethodAction maps request methods to the action they require.
+// HEAD and OPTIONS read like GET, CORS preflight requests are let through by the middleware before this mapping applies.
+var MethodAction = map[string]PermissionAction{
+	http.MethodGet:     ActionRead,
+	http.MethodHead:    ActionRead,
+	http.MethodOptions: ActionRead,
+	http.MethodPost:    ActionWrite,
+	http.MethodPut:     ActionWrite,
+	http.MethodPatch:   ActionWrite,
+	http.MethodDelete:  ActionDelete,
+}
@@ -0,0 +1,35 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var actionTestExport = types.RegisterAction("Test_Export")
+
+func TestRegisterAction(t *testing.T) {
+	assert.Equal(t, types.PermissionAction(8), actionTestExport, "first custom action takes the next free bit")
+	assert.Equal(t, []string{"read", "test_export"}, (types.ActionRead | actionTestExport).Names())
+
+	action, ok := types.ParsePermissionAction("TEST_EXPORT")
+	assert.True(t, ok)
+	assert.Equal(t, actionTestExport, action)
+
+	assert.Panics(t, func() { types.RegisterAction("test_export") })
+	assert.Panics(t, func() { types.RegisterAction(" ") })
+}
+
+func TestExtendPermission(t *testing.T) {
+	report := types.PermissionReportSlot
+	perms := types.PermissionsDTO{report: types.ActionRead | actionTestExport}
+	assert.False(t, perms.Has(report, actionTestExport), "undeclared action is not granted")
+
+	types.ExtendPermission(report, actionTestExport)
+	assert.True(t, perms.Has(report, actionTestExport))
+	def, _ := types.LookupPermission(string(report))
+	assert.Equal(t, types.ActionAll|actionTestExport, def.Actions)
+
+	assert.Panics(t, func() { types.ExtendPermission("test_unknown", actionTestExport) })
+}
@@ -0,0 +1,10 @@
+package types
+
+// IntegratorClient is an integrator agent allowed to call the aggregator API with JWTClaimsSignature tokens
+type IntegratorClient struct {
+	ID         string     `json:"id"`
+	Status     UserStatus `json:"status"`
+	Secret     string     `json:"-"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"` // IPs or CIDR ranges
+	Namespace  string     `json:"namespace,omitempty"`
+}
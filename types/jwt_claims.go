@@ -12,13 +12,24 @@ type JWTClaims struct {
 	Email           string         `json:"email"`
 	Type            Role           `json:"position_type"`
 	Permissions     PermissionsDTO `json:"permissions"`
+	// AuthTime is when the user last entered a password or OTP, it must be kept as is when the token is refreshed
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// AMR lists the methods used at AuthTime, e.g. ["pwd","otp"], see AMRPassword
+	AMR []string `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// EffectivePermissions returns the permissions carried by the token merged on top of the role defaults
+func (c *JWTClaims) EffectivePermissions() PermissionsDTO {
+	return c.Type.EffectivePermissions(c.Permissions)
+}
+
 type JWTClaimsSignature struct {
 	ClientId     string `json:"client_id"`
 	UserId       string `json:"user_id"`
 	SuperAgentId string `json:"super_agent_id"`
+	// ClientSecret is never used to verify signatures, the token is sent with every request.
+	// v2 signatures are verified with the secret loaded by the ClientResolver.
 	ClientSecret string `json:"client_secret"`
 	jwt.RegisteredClaims
 }
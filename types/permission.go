@@ -1,17 +1,161 @@
 package types
 
-type PermissionsDTO struct {
-	Dashboard                  int `json:"dashboard"`
-	ReportPlayerActive         int `json:"report_player_active"`
-	ReportClients              int `json:"report_clients"`
-	ReportSlot                 int `json:"report_slot"`
-	ReportProfit               int `json:"report_profit"`
-	ReportClientShared         int `json:"report_client_shared"`
-	SuperAgent                 int `json:"super_agent"`
-	Agent                      int `json:"agent"`
-	GameProviders              int `json:"game_providers"`
-	Games                      int `json:"games"`
-	PlayerPendingTransaction   int `json:"player_pending_transaction"`
-	Settings                   int `json:"settings"`
-	PermissionRegenerateSecret int `json:"permission_regenerate_secret"`
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type Permission string
+
+const (
+	PermissionDashboard          Permission = "dashboard"
+	PermissionReportPlayerActive Permission = "report_player_active"
+	PermissionReportClients      Permission = "report_clients"
+	PermissionReportSlot         Permission = "report_slot"
+	PermissionReportProfit       Permission = "report_profit"
+	PermissionReportClientShared Permission = "report_client_shared"
+	PermissionSuperAgent         Permission = "super_agent"
+	PermissionAgent              Permission = "agent"
+	PermissionGameProviders      Permission = "game_providers"
+	PermissionGames              Permission = "games"
+	PermissionPlayerPendingTxn   Permission = "player_pending_transaction"
+	PermissionSettings           Permission = "settings"
+	PermissionRegenerateSecret   Permission = "permission_regenerate_secret"
+)
+
+// PermissionDefinition declares a permission: its key in claims and queries, a description and the actions it can grant
+type PermissionDefinition struct {
+	Key         Permission       `json:"key"`
+	Description string           `json:"description"`
+	Actions     PermissionAction `json:"actions"`
+	// StepUp requires a recent authentication to use the permission, see SetPermissionStepUp
+	StepUp *StepUpRequirement `json:"step_up,omitempty"`
+}
+
+var permissionRegistry = struct {
+	mu    sync.RWMutex
+	defs  []PermissionDefinition
+	byKey map[Permission]int
+}{byKey: make(map[Permission]int)}
+
+func init() {
+	for _, def := range []PermissionDefinition{
+		{Key: PermissionDashboard, Description: "Dashboard summary", Actions: ActionAll},
+		{Key: PermissionReportPlayerActive, Description: "Active player report", Actions: ActionAll},
+		{Key: PermissionReportClients, Description: "Client report", Actions: ActionAll},
+		{Key: PermissionReportSlot, Description: "Slot report", Actions: ActionAll},
+		{Key: PermissionReportProfit, Description: "Profit report", Actions: ActionAll},
+		{Key: PermissionReportClientShared, Description: "Client shared report", Actions: ActionAll},
+		{Key: PermissionSuperAgent, Description: "Super agent management", Actions: ActionAll},
+		{Key: PermissionAgent, Description: "Agent management", Actions: ActionAll},
+		{Key: PermissionGameProviders, Description: "Game provider management", Actions: ActionAll},
+		{Key: PermissionGames, Description: "Game management", Actions: ActionAll},
+		{Key: PermissionPlayerPendingTxn, Description: "Player pending transactions", Actions: ActionAll},
+		{Key: PermissionSettings, Description: "Settings", Actions: ActionAll},
+		{Key: PermissionRegenerateSecret, Description: "Regenerate client secrets", Actions: ActionAll},
+	} {
+		RegisterPermission(def)
+	}
+}
+
+// RegisterPermission : Declare a permission. It panics when the key is empty or already registered,
+// permissions are expected to be registered at init time.
+func RegisterPermission(def PermissionDefinition) {
+	key := Permission(strings.ToLower(strings.TrimSpace(string(def.Key))))
+	if key == "" {
+		panic("types: permission key is required")
+	}
+	def.Key = key
+
+	permissionRegistry.mu.Lock()
+	defer permissionRegistry.mu.Unlock()
+	if _, ok := permissionRegistry.byKey[key]; ok {
+		panic(fmt.Sprintf("types: permission %q registered twice", key))
+	}
+	permissionRegistry.byKey[key] = len(permissionRegistry.defs)
+	permissionRegistry.defs = append(permissionRegistry.defs, def)
+}
+
+// ExtendPermission : Add actions to those a registered permission can grant, e.g. an action from RegisterAction.
+// It panics when the permission is not registered.
+func ExtendPermission(key Permission, actions PermissionAction) {
+	key = Permission(strings.ToLower(strings.TrimSpace(string(key))))
+
+	permissionRegistry.mu.Lock()
+	defer permissionRegistry.mu.Unlock()
+	i, ok := permissionRegistry.byKey[key]
+	if !ok {
+		panic(fmt.Sprintf("types: permission %q not registered", key))
+	}
+	permissionRegistry.defs[i].Actions |= actions
+}
+
+// LookupPermission : Get the definition of a permission, keys are matched case-insensitively
+func LookupPermission(key string) (PermissionDefinition, bool) {
+	permissionRegistry.mu.RLock()
+	defer permissionRegistry.mu.RUnlock()
+
+	i, ok := permissionRegistry.byKey[Permission(strings.ToLower(strings.TrimSpace(key)))]
+	if !ok {
+		return PermissionDefinition{}, false
+	}
+
+	return permissionRegistry.defs[i], true
+}
+
+// ParsePermission : Parse a permission key, e.g. from the "role" or "type" query
+func ParsePermission(key string) (Permission, bool) {
+	def, ok := LookupPermission(key)
+	return def.Key, ok
+}
+
+// RegisteredPermissions : Get all registered permissions in registration order
+func RegisteredPermissions() []PermissionDefinition {
+	permissionRegistry.mu.RLock()
+	defer permissionRegistry.mu.RUnlock()
+
+	return append([]PermissionDefinition(nil), permissionRegistry.defs...)
+}
+
+// PermissionsDTO holds the actions granted per permission.
+// It serialises as {"dashboard":3,"games":1,...} with every registered permission present, as the former struct did.
+type PermissionsDTO map[Permission]PermissionAction
+
+// Get returns the actions granted for p
+func (p PermissionsDTO) Get(permission Permission) PermissionAction {
+	return p[permission]
+}
+
+// Has reports whether action is granted for a registered permission.
+// Actions the permission does not declare are never granted.
+func (p PermissionsDTO) Has(permission Permission, action PermissionAction) bool {
+	def, ok := LookupPermission(string(permission))
+	if !ok {
+		return false
+	}
+
+	return p[def.Key]&action&def.Actions != 0
+}
+
+// Grant adds action to the actions granted for permission
+func (p *PermissionsDTO) Grant(permission Permission, action PermissionAction) {
+	if *p == nil {
+		*p = make(PermissionsDTO)
+	}
+	(*p)[permission] |= action
+}
+
+// MarshalJSON writes every registered permission, with 0 when not granted, followed by unregistered entries
+func (p PermissionsDTO) MarshalJSON() ([]byte, error) {
+	out := make(map[Permission]PermissionAction, len(p))
+	for _, def := range RegisteredPermissions() {
+		out[def.Key] = p[def.Key]
+	}
+	for permission, action := range p {
+		out[permission] = action
+	}
+
+	return json.Marshal(out)
 }
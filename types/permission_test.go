@@ -0,0 +1,80 @@
+package types_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyPermissions is the JSON issued by services still using the former PermissionsDTO struct
+const legacyPermissions = `{"dashboard":1,"report_player_active":0,"report_clients":0,"report_slot":0,"report_profit":3,` +
+	`"report_client_shared":0,"super_agent":0,"agent":0,"game_providers":0,"games":7,"player_pending_transaction":0,` +
+	`"settings":0,"permission_regenerate_secret":0}`
+
+func TestPermissionsDTO_JSON(t *testing.T) {
+	var perms types.PermissionsDTO
+	require.NoError(t, json.Unmarshal([]byte(legacyPermissions), &perms))
+
+	assert.Equal(t, types.ActionRead, perms.Get(types.PermissionDashboard))
+	assert.Equal(t, types.ActionRead|types.ActionWrite, perms.Get(types.PermissionReportProfit))
+	assert.Equal(t, types.ActionAll, perms.Get(types.PermissionGames))
+
+	b, err := json.Marshal(perms)
+	require.NoError(t, err)
+	assert.JSONEq(t, legacyPermissions, string(b))
+
+	// Missing permissions are written as 0, like the zero value of the former struct
+	b, err = json.Marshal(types.PermissionsDTO{types.PermissionDashboard: types.ActionRead})
+	require.NoError(t, err)
+	var decoded map[string]int
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Len(t, decoded, len(types.RegisteredPermissions()))
+	assert.Equal(t, 1, decoded["dashboard"])
+	assert.Equal(t, 0, decoded["games"])
+}
+
+func TestPermissionsDTO_Has(t *testing.T) {
+	types.RegisterPermission(types.PermissionDefinition{Key: "test_read_only", Description: "Read only", Actions: types.ActionRead})
+
+	perms := types.PermissionsDTO{}
+	perms.Grant(types.PermissionGames, types.ActionRead)
+	perms.Grant(types.PermissionGames, types.ActionDelete)
+	perms.Grant("test_read_only", types.ActionAll)
+	perms.Grant("unregistered", types.ActionAll)
+
+	tests := []struct {
+		name       string
+		permission types.Permission
+		action     types.PermissionAction
+		want       bool
+	}{
+		{name: "success - granted action", permission: types.PermissionGames, action: types.ActionRead, want: true},
+		{name: "success - second granted action", permission: types.PermissionGames, action: types.ActionDelete, want: true},
+		{name: "error - action not granted", permission: types.PermissionGames, action: types.ActionWrite},
+		{name: "error - permission not granted", permission: types.PermissionSettings, action: types.ActionRead},
+		{name: "success - declared action", permission: "test_read_only", action: types.ActionRead, want: true},
+		{name: "error - action not declared by the permission", permission: "test_read_only", action: types.ActionWrite},
+		{name: "error - unregistered permission", permission: "unregistered", action: types.ActionRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, perms.Has(tt.permission, tt.action))
+		})
+	}
+}
+
+func TestRegisterPermission(t *testing.T) {
+	permission, ok := types.ParsePermission(" Report_Profit ")
+	assert.True(t, ok)
+	assert.Equal(t, types.PermissionReportProfit, permission)
+
+	_, ok = types.ParsePermission("unknown")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { types.RegisterPermission(types.PermissionDefinition{Key: types.PermissionGames}) })
+	assert.Panics(t, func() { types.RegisterPermission(types.PermissionDefinition{Key: " "}) })
+}
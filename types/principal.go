@@ -0,0 +1,116 @@
+package types
+
+// PrincipalKind represents the kind of caller behind an authenticated request
+type PrincipalKind string
+
+const (
+	PrincipalKindUser    PrincipalKind = "user"    // admin panel user authenticated with JWTClaims
+	PrincipalKindClient  PrincipalKind = "client"  // integrator client authenticated with JWTClaimsSignature
+	PrincipalKindService PrincipalKind = "service" // internal service authenticated with a static secret
+)
+
+// Principal is the identity of an authenticated caller, regardless of which auth chain produced it
+type Principal interface {
+	PrincipalID() string
+	PrincipalNamespace() string
+	PrincipalParentNamespace() string
+	PrincipalRole() Role
+	// PrincipalPermissions returns the effective permissions, the role defaults included
+	PrincipalPermissions() PermissionsDTO
+	PrincipalKind() PrincipalKind
+}
+
+// PrincipalID returns the user id
+func (c *JWTClaims) PrincipalID() string {
+	return c.ID
+}
+
+// PrincipalNamespace returns the namespace of the user
+func (c *JWTClaims) PrincipalNamespace() string {
+	return c.Namespace
+}
+
+// PrincipalParentNamespace returns the parent namespace of the user
+func (c *JWTClaims) PrincipalParentNamespace() string {
+	return c.ParentNamespace
+}
+
+// PrincipalRole returns the position type of the user
+func (c *JWTClaims) PrincipalRole() Role {
+	return c.Type
+}
+
+// PrincipalPermissions returns the permissions carried by the token merged on top of the role defaults, see EffectivePermissions
+func (c *JWTClaims) PrincipalPermissions() PermissionsDTO {
+	return c.EffectivePermissions()
+}
+
+// PrincipalKind always returns PrincipalKindUser
+func (c *JWTClaims) PrincipalKind() PrincipalKind {
+	return PrincipalKindUser
+}
+
+// PrincipalID returns the client id
+func (c *JWTClaimsSignature) PrincipalID() string {
+	return c.ClientId
+}
+
+// PrincipalNamespace returns an empty string, integrator tokens carry no namespace
+func (c *JWTClaimsSignature) PrincipalNamespace() string {
+	return ""
+}
+
+// PrincipalParentNamespace returns an empty string, integrator tokens carry no namespace
+func (c *JWTClaimsSignature) PrincipalParentNamespace() string {
+	return ""
+}
+
+// PrincipalRole always returns RoleClient
+func (c *JWTClaimsSignature) PrincipalRole() Role {
+	return RoleClient
+}
+
+// PrincipalPermissions returns the RoleClient defaults, integrator tokens carry no permissions
+func (c *JWTClaimsSignature) PrincipalPermissions() PermissionsDTO {
+	return RoleClient.Permissions()
+}
+
+// PrincipalKind always returns PrincipalKindClient
+func (c *JWTClaimsSignature) PrincipalKind() PrincipalKind {
+	return PrincipalKindClient
+}
+
+// ServicePrincipal is an internal service authenticated with a shared static secret
+type ServicePrincipal struct {
+	ID string
+}
+
+// PrincipalID returns the service id
+func (p *ServicePrincipal) PrincipalID() string {
+	return p.ID
+}
+
+// PrincipalNamespace returns an empty string, services are not bound to a namespace
+func (p *ServicePrincipal) PrincipalNamespace() string {
+	return ""
+}
+
+// PrincipalParentNamespace returns an empty string, services are not bound to a namespace
+func (p *ServicePrincipal) PrincipalParentNamespace() string {
+	return ""
+}
+
+// PrincipalRole always returns RoleService
+func (p *ServicePrincipal) PrincipalRole() Role {
+	return RoleService
+}
+
+// PrincipalPermissions returns the RoleService defaults, services carry no permissions
+func (p *ServicePrincipal) PrincipalPermissions() PermissionsDTO {
+	return RoleService.Permissions()
+}
+
+// PrincipalKind always returns PrincipalKindService
+func (p *ServicePrincipal) PrincipalKind() PrincipalKind {
+	return PrincipalKindService
+}
@@ -1,48 +1,102 @@
 package types
 
 import (
-	"net/http"
+	"sync"
 )
 
-type Permission string
-
 // Role represents the role of a user
 type Role string
 
 const (
-	RoleAdmin  Role = "admin"
-	RoleClient Role = "client"
-	RoleUser   Role = "user"
+	RoleAdmin   Role = "admin"
+	RoleClient  Role = "client"
+	RoleUser    Role = "user"
+	RoleService Role = "service"
+	// RoleAPIKey is the default role of API keys. It never grants permissions, so a key is bounded by its scopes.
+	RoleAPIKey Role = "api_key"
 )
 
-const (
-	PermissionDashboard          Permission = "dashboard"
-	PermissionReportPlayerActive Permission = "report_player_active"
-	PermissionReportClients      Permission = "report_clients"
-	PermissionReportSlot         Permission = "report_slot"
-	PermissionReportProfit       Permission = "report_profit"
-	PermissionReportClientShared Permission = "report_client_shared"
-	PermissionSuperAgent         Permission = "super_agent"
-	PermissionAgent              Permission = "agent"
-	PermissionGameProviders      Permission = "game_providers"
-	PermissionGames              Permission = "games"
-	PermissionPlayerPendingTxn   Permission = "player_pending_transaction"
-	PermissionSettings           Permission = "settings"
-	PermissionRegenerateSecret   Permission = "permission_regenerate_secret"
-)
+// RoleDefinition declares the permissions granted by default to a role and the roles it inherits from
+type RoleDefinition struct {
+	Role        Role           `json:"role"`
+	Inherits    []Role         `json:"inherits,omitempty"`
+	Permissions PermissionsDTO `json:"permissions"`
+}
 
-type PermissionAction int
+var roleRegistry = struct {
+	mu    sync.RWMutex
+	roles map[Role]RoleDefinition
+}{roles: make(map[Role]RoleDefinition)}
 
-const (
-	ActionRead   PermissionAction = 1 << iota // 1
-	ActionWrite                               // 2
-	ActionDelete                              // 4
-)
+func init() {
+	for _, role := range []Role{RoleAdmin, RoleClient, RoleUser, RoleService, RoleAPIKey} {
+		RegisterRole(RoleDefinition{Role: role})
+	}
+}
+
+// RegisterRole : Declare a role or replace its definition, e.g. after loading role grants from the database.
+// Built-in roles are registered without grants, so tokens keep carrying the full permission set until configured.
+// It panics when RoleAPIKey is given permissions or parent roles.
+func RegisterRole(def RoleDefinition) {
+	if def.Role == "" {
+		panic("types: role is required")
+	}
+	if def.Role == RoleAPIKey && (len(def.Permissions) > 0 || len(def.Inherits) > 0) {
+		panic("types: role api_key cannot grant permissions")
+	}
+
+	roleRegistry.mu.Lock()
+	defer roleRegistry.mu.Unlock()
+	roleRegistry.roles[def.Role] = def
+}
+
+// LookupRole : Get the definition of a role
+func LookupRole(role Role) (RoleDefinition, bool) {
+	roleRegistry.mu.RLock()
+	defer roleRegistry.mu.RUnlock()
+
+	def, ok := roleRegistry.roles[role]
+	return def, ok
+}
+
+// Permissions returns the default permissions of the role, including those of the roles it inherits from.
+// Unknown roles grant nothing and inheritance cycles are ignored.
+func (r Role) Permissions() PermissionsDTO {
+	roleRegistry.mu.RLock()
+	defer roleRegistry.mu.RUnlock()
+
+	perms := PermissionsDTO{}
+	visited := make(map[Role]bool)
+	var collect func(role Role)
+	collect = func(role Role) {
+		if visited[role] {
+			return
+		}
+		visited[role] = true
+
+		def, ok := roleRegistry.roles[role]
+		if !ok {
+			return
+		}
+		for permission, action := range def.Permissions {
+			perms.Grant(permission, action)
+		}
+		for _, parent := range def.Inherits {
+			collect(parent)
+		}
+	}
+	collect(r)
+
+	return perms
+}
+
+// EffectivePermissions merges overrides, e.g. the permissions carried by a token, on top of the role defaults.
+// Overrides can only add actions, a user is restricted by assigning a narrower role.
+func (r Role) EffectivePermissions(overrides PermissionsDTO) PermissionsDTO {
+	perms := r.Permissions()
+	for permission, action := range overrides {
+		perms.Grant(permission, action)
+	}
 
-var MethodAction = map[string]PermissionAction{
-	http.MethodGet:    ActionRead,
-	http.MethodPost:   ActionWrite,
-	http.MethodPut:    ActionWrite,
-	http.MethodPatch:  ActionWrite,
-	http.MethodDelete: ActionDelete,
+	return perms
 }
@@ -0,0 +1,96 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRole_EffectivePermissions(t *testing.T) {
+	const (
+		viewer   types.Role = "test_viewer"
+		operator types.Role = "test_operator"
+		cyclic   types.Role = "test_cyclic"
+	)
+	types.RegisterRole(types.RoleDefinition{
+		Role:        viewer,
+		Permissions: types.PermissionsDTO{types.PermissionDashboard: types.ActionRead, types.PermissionGames: types.ActionRead},
+	})
+	types.RegisterRole(types.RoleDefinition{
+		Role:        operator,
+		Inherits:    []types.Role{viewer, cyclic, "test_missing"},
+		Permissions: types.PermissionsDTO{types.PermissionGames: types.ActionWrite},
+	})
+	types.RegisterRole(types.RoleDefinition{Role: cyclic, Inherits: []types.Role{operator}})
+
+	tests := []struct {
+		name      string
+		role      types.Role
+		overrides types.PermissionsDTO
+		want      types.PermissionsDTO
+	}{
+		{
+			name: "success - role defaults",
+			role: viewer,
+			want: types.PermissionsDTO{types.PermissionDashboard: types.ActionRead, types.PermissionGames: types.ActionRead},
+		},
+		{
+			name: "success - inherited defaults are merged",
+			role: operator,
+			want: types.PermissionsDTO{types.PermissionDashboard: types.ActionRead, types.PermissionGames: types.ActionRead | types.ActionWrite},
+		},
+		{
+			name:      "success - overrides add to the defaults",
+			role:      viewer,
+			overrides: types.PermissionsDTO{types.PermissionGames: types.ActionDelete, types.PermissionSettings: types.ActionRead},
+			want: types.PermissionsDTO{
+				types.PermissionDashboard: types.ActionRead,
+				types.PermissionGames:     types.ActionRead | types.ActionDelete,
+				types.PermissionSettings:  types.ActionRead,
+			},
+		},
+		{
+			name:      "success - zero overrides from legacy tokens keep the defaults",
+			role:      viewer,
+			overrides: types.PermissionsDTO{types.PermissionDashboard: 0, types.PermissionGames: 0},
+			want:      types.PermissionsDTO{types.PermissionDashboard: types.ActionRead, types.PermissionGames: types.ActionRead},
+		},
+		{
+			name:      "success - built-in role only has the overrides",
+			role:      types.RoleAdmin,
+			overrides: types.PermissionsDTO{types.PermissionAgent: types.ActionAll},
+			want:      types.PermissionsDTO{types.PermissionAgent: types.ActionAll},
+		},
+		{
+			name: "success - unknown role grants nothing",
+			role: "test_unknown",
+			want: types.PermissionsDTO{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.role.EffectivePermissions(tt.overrides))
+		})
+	}
+}
+
+func TestRegisterRole_APIKey(t *testing.T) {
+	assert.Panics(t, func() {
+		types.RegisterRole(types.RoleDefinition{Role: types.RoleAPIKey, Permissions: types.PermissionsDTO{types.PermissionGames: types.ActionRead}})
+	})
+	assert.Panics(t, func() {
+		types.RegisterRole(types.RoleDefinition{Role: types.RoleAPIKey, Inherits: []types.Role{types.RoleAdmin}})
+	})
+	assert.Equal(t, types.PermissionsDTO{}, types.RoleAPIKey.Permissions())
+}
+
+func TestJWTClaims_PrincipalPermissions(t *testing.T) {
+	const auditor types.Role = "test_auditor"
+	types.RegisterRole(types.RoleDefinition{Role: auditor, Permissions: types.PermissionsDTO{types.PermissionDashboard: types.ActionRead}})
+
+	claims := &types.JWTClaims{Type: auditor, Permissions: types.PermissionsDTO{types.PermissionGames: types.ActionRead}}
+	assert.Equal(t, claims.EffectivePermissions(), claims.PrincipalPermissions())
+	assert.True(t, claims.PrincipalPermissions().Has(types.PermissionDashboard, types.ActionRead))
+}
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Authentication method references carried in the "amr" claim (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+)
+
+// StepUpRequirement requires the user to have authenticated recently to use a permission
+type StepUpRequirement struct {
+	// MaxAge is the maximum time since the "auth_time" claim
+	MaxAge time.Duration `json:"max_age"`
+	// Actions are the actions requiring a recent authentication, every action when 0
+	Actions PermissionAction `json:"actions,omitempty"`
+	// Methods are the accepted "amr" values, any method when empty
+	Methods []string `json:"methods,omitempty"`
+}
+
+// AppliesTo reports whether action requires a recent authentication
+func (s StepUpRequirement) AppliesTo(action PermissionAction) bool {
+	return s.Actions == 0 || s.Actions&action != 0
+}
+
+// SatisfiedBy reports whether claims authenticated within MaxAge before now with an accepted method
+func (s StepUpRequirement) SatisfiedBy(claims *JWTClaims, now time.Time) bool {
+	if claims.AuthTime == nil {
+		return false
+	}
+	age := now.Sub(claims.AuthTime.Time)
+	if age > s.MaxAge || age < -time.Minute {
+		return false
+	}
+	if len(s.Methods) == 0 {
+		return true
+	}
+
+	for _, method := range claims.AMR {
+		if slices.Contains(s.Methods, strings.ToLower(method)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetPermissionStepUp : Require a recent authentication to use a registered permission,
+// e.g. SetPermissionStepUp(PermissionSettings, StepUpRequirement{MaxAge: 5 * time.Minute, Actions: ActionWrite | ActionDelete}).
+// It panics when the permission is not registered or MaxAge is not positive.
+func SetPermissionStepUp(key Permission, req StepUpRequirement) {
+	if req.MaxAge <= 0 {
+		panic(fmt.Sprintf("types: step-up max age of %q must be positive", key))
+	}
+	methods := make([]string, len(req.Methods))
+	for i, method := range req.Methods {
+		methods[i] = strings.ToLower(strings.TrimSpace(method))
+	}
+	req.Methods = methods
+	key = Permission(strings.ToLower(strings.TrimSpace(string(key))))
+
+	permissionRegistry.mu.Lock()
+	defer permissionRegistry.mu.Unlock()
+	i, ok := permissionRegistry.byKey[key]
+	if !ok {
+		panic(fmt.Sprintf("types: permission %q not registered", key))
+	}
+	permissionRegistry.defs[i].StepUp = &req
+}
@@ -0,0 +1,55 @@
+package types_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SoeltanIT/agg-common-be/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepUpRequirement_SatisfiedBy(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	req := types.StepUpRequirement{MaxAge: 5 * time.Minute, Methods: []string{types.AMRPassword, types.AMROTP}}
+
+	tests := []struct {
+		name     string
+		authTime *jwt.NumericDate
+		amr      []string
+		req      types.StepUpRequirement
+		want     bool
+	}{
+		{name: "success - recent password", authTime: jwt.NewNumericDate(now.Add(-time.Minute)), amr: []string{"pwd"}, req: req, want: true},
+		{name: "success - method matched case-insensitively", authTime: jwt.NewNumericDate(now.Add(-time.Minute)), amr: []string{"OTP"}, req: req, want: true},
+		{name: "success - any method", authTime: jwt.NewNumericDate(now.Add(-5 * time.Minute)), req: types.StepUpRequirement{MaxAge: 5 * time.Minute}, want: true},
+		{name: "error - too old", authTime: jwt.NewNumericDate(now.Add(-6 * time.Minute)), amr: []string{"pwd"}, req: req},
+		{name: "error - no auth_time", amr: []string{"pwd"}, req: req},
+		{name: "error - method not accepted", authTime: jwt.NewNumericDate(now.Add(-time.Minute)), amr: []string{"kba"}, req: req},
+		{name: "error - auth_time in the future", authTime: jwt.NewNumericDate(now.Add(time.Hour)), amr: []string{"pwd"}, req: req},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &types.JWTClaims{AuthTime: tt.authTime, AMR: tt.amr}
+			assert.Equal(t, tt.want, tt.req.SatisfiedBy(claims, now))
+		})
+	}
+}
+
+func TestSetPermissionStepUp(t *testing.T) {
+	types.SetPermissionStepUp(types.PermissionSettings, types.StepUpRequirement{
+		MaxAge: 5 * time.Minute, Actions: types.ActionWrite | types.ActionDelete, Methods: []string{" PWD "},
+	})
+
+	def, ok := types.LookupPermission(string(types.PermissionSettings))
+	assert.True(t, ok)
+	if assert.NotNil(t, def.StepUp) {
+		assert.Equal(t, []string{"pwd"}, def.StepUp.Methods)
+		assert.True(t, def.StepUp.AppliesTo(types.ActionWrite))
+		assert.False(t, def.StepUp.AppliesTo(types.ActionRead))
+	}
+
+	assert.Panics(t, func() { types.SetPermissionStepUp("test_unknown", types.StepUpRequirement{MaxAge: time.Minute}) })
+	assert.Panics(t, func() { types.SetPermissionStepUp(types.PermissionSettings, types.StepUpRequirement{}) })
+}